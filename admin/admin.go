@@ -0,0 +1,264 @@
+// Package admin 提供需要令牌认证的管理接口，用于在不登录服务器的情况下控制节点
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uright008/go-openbmclapi-reborn/cluster"
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
+	"github.com/uright008/go-openbmclapi-reborn/storage"
+	"github.com/uright008/go-openbmclapi-reborn/token"
+)
+
+// Server 管理接口服务器，监听独立的地址
+type Server struct {
+	cluster *cluster.Cluster
+	config  config.AdminConfig
+	logger  *logger.Logger
+	server  *http.Server
+}
+
+// NewServer 创建管理接口服务器
+func NewServer(cluster *cluster.Cluster, cfg config.AdminConfig, logger *logger.Logger) *Server {
+	return &Server{
+		cluster: cluster,
+		config:  cfg,
+		logger:  logger,
+	}
+}
+
+// Handler 返回管理接口的路由，所有接口都需要认证
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("POST /api/sync", s.handleSync)
+	mux.HandleFunc("POST /api/gc", s.handleGC)
+	mux.HandleFunc("POST /api/enable", s.handleEnable)
+	mux.HandleFunc("POST /api/disable", s.handleDisable)
+	mux.HandleFunc("GET /api/config", s.handleConfig)
+	mux.HandleFunc("GET /api/missing", s.handleMissing)
+	mux.HandleFunc("GET /api/debug", s.handleGetDebug)
+	mux.HandleFunc("PUT /api/debug", s.handleSetDebug)
+
+	// 未配置单独的指标监听地址时，指标由管理接口提供，同样需要认证
+	if metrics := s.cluster.Config.Metrics; metrics.Enabled && metrics.Listen == "" {
+		mux.Handle("GET /metrics", promhttp.Handler())
+	}
+
+	return s.authenticate(mux)
+}
+
+// Start 启动管理接口，未配置令牌时拒绝启动
+func (s *Server) Start() error {
+	if s.config.Token == "" {
+		return fmt.Errorf("未配置管理接口令牌 admin.token")
+	}
+
+	s.server = &http.Server{
+		Addr:              s.config.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.logger.Info("管理接口已启动: %s", s.config.Listen)
+	return s.server.ListenAndServe()
+}
+
+// Stop 关闭管理接口
+func (s *Server) Stop(ctx context.Context) error {
+	if s.server != nil {
+		return s.server.Shutdown(ctx)
+	}
+	return nil
+}
+
+// authenticate 校验Authorization头中的Bearer令牌
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.config.Token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		s.logger.Info("[管理接口] %s %s 来自 %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusResponse 节点状态
+type statusResponse struct {
+	Enabled     bool                       `json:"enabled"`
+	Healthy     bool                       `json:"healthy"`
+	Syncing     bool                       `json:"syncing"`
+	LastSync    time.Time                  `json:"lastSync"`
+	LastGC      time.Time                  `json:"lastGC"`
+	Hits        int64                      `json:"hits"`
+	Bytes       int64                      `json:"bytes"`
+	PendingHits int64                      `json:"pendingHits"`
+	GCFiles     int64                      `json:"gcFiles"`
+	GCBytes     int64                      `json:"gcBytes"`
+	Token       token.State                `json:"token"`
+	Breakers    []resilience.BreakerStatus `json:"breakers"`
+}
+
+// handleStatus 返回节点当前状态
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	scheduler := s.cluster.Scheduler()
+	total := s.cluster.Stats().Total()
+	gcFiles, gcBytes := s.cluster.Stats().GCTotal()
+
+	writeJSON(w, http.StatusOK, statusResponse{
+		Enabled:     s.cluster.IsEnabled(),
+		Healthy:     s.cluster.Healthy(),
+		Syncing:     scheduler.Running(),
+		LastSync:    scheduler.LastRun(),
+		LastGC:      scheduler.LastGC(),
+		Hits:        total.Hits,
+		Bytes:       total.Bytes,
+		PendingHits: s.cluster.Stats().Pending().Hits,
+		GCFiles:     gcFiles,
+		GCBytes:     gcBytes,
+		Token:       s.cluster.TokenState(),
+		Breakers:    s.cluster.BreakerStatus(),
+	})
+}
+
+// handleSync 触发一次同步，不等待完成
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if s.cluster.Scheduler().Running() {
+		writeError(w, http.StatusConflict, cluster.ErrSyncInProgress)
+		return
+	}
+
+	triggered := s.cluster.Scheduler().Trigger()
+	writeJSON(w, http.StatusAccepted, map[string]bool{"triggered": triggered})
+}
+
+// handleGC 立即执行一次垃圾回收，?dry_run=true时只统计不删除
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	dryRun := s.cluster.Config.GC.DryRun
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("无效的dry_run参数: %s", value))
+			return
+		}
+		dryRun = parsed
+	}
+
+	result, err := s.cluster.Scheduler().RunGC(r.Context(), dryRun)
+	switch {
+	case errors.Is(err, cluster.ErrSyncInProgress):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, storage.ErrGCThresholdExceeded):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// handleEnable 向中心服务器启用节点，并清除手动禁用标记
+func (s *Server) handleEnable(w http.ResponseWriter, r *http.Request) {
+	if err := s.cluster.ManualEnable(r.Context()); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": s.cluster.IsEnabled()})
+}
+
+// handleDisable 向中心服务器禁用节点，之后不会被自动重新启用
+func (s *Server) handleDisable(w http.ResponseWriter, r *http.Request) {
+	if err := s.cluster.ManualDisable(r.Context()); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": s.cluster.IsEnabled()})
+}
+
+// handleConfig 返回隐去敏感字段的当前配置
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.cluster.Config.Redacted())
+}
+
+// missingResponse 缺失文件列表
+type missingResponse struct {
+	Count int                 `json:"count"`
+	Bytes int64               `json:"bytes"`
+	Files []*storage.FileInfo `json:"files"`
+}
+
+// handleMissing 根据完整文件列表返回存储中缺失的文件
+func (s *Server) handleMissing(w http.ResponseWriter, r *http.Request) {
+	files, err := s.cluster.MissingFiles(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	resp := missingResponse{
+		Count: len(files),
+		Files: files,
+	}
+	if resp.Files == nil {
+		resp.Files = []*storage.FileInfo{}
+	}
+	for _, file := range files {
+		resp.Bytes += file.Size
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// debugRequest 调试模式开关
+type debugRequest struct {
+	Debug bool `json:"debug"`
+}
+
+// handleGetDebug 返回当前是否处于调试模式
+func (s *Server) handleGetDebug(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, debugRequest{Debug: s.logger.IsDebug()})
+}
+
+// handleSetDebug 在运行时切换调试日志
+func (s *Server) handleSetDebug(w http.ResponseWriter, r *http.Request) {
+	var req debugRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("无法解析请求: %w", err))
+		return
+	}
+
+	s.logger.SetDebug(req.Debug)
+	if req.Debug {
+		s.logger.Info("调试模式已开启")
+	} else {
+		s.logger.Info("调试模式已关闭")
+	}
+	writeJSON(w, http.StatusOK, debugRequest{Debug: s.logger.IsDebug()})
+}
+
+// writeJSON 以JSON格式写出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 以JSON格式写出错误
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uright008/go-openbmclapi-reborn/cluster"
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+const testToken = "admin-token"
+
+// newTestServer 创建未连接中心服务器的集群及其管理接口
+func newTestServer(t *testing.T, metrics config.MetricsConfig) *Server {
+	t.Helper()
+	cfg := &config.Config{
+		Cluster: config.ClusterConfig{ID: "test-cluster", Secret: "test-secret", ServerURL: "http://127.0.0.1:1"},
+		Storage: config.StorageConfig{Type: "file", Path: t.TempDir()},
+		System:  config.SystemConfig{DataDir: t.TempDir()},
+		Sync:    config.SyncConfig{IntervalMinutes: 10, DisableThreshold: 100},
+		Metrics: metrics,
+		Admin:   config.AdminConfig{Enabled: true, Token: testToken},
+	}
+	c, err := cluster.NewCluster(cfg, logger.New(false))
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	return NewServer(c, cfg.Admin, logger.New(false))
+}
+
+// get 以token认证请求管理接口，token为空时不带认证
+func get(h http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMetricsRequireToken(t *testing.T) {
+	h := newTestServer(t, config.MetricsConfig{Enabled: true}).Handler()
+
+	if rec := get(h, "/metrics", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("未认证时状态码 = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := get(h, "/metrics", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("令牌错误时状态码 = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := get(h, "/metrics", testToken); rec.Code != http.StatusOK {
+		t.Errorf("认证后状态码 = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMetricsOnSeparateListener(t *testing.T) {
+	h := newTestServer(t, config.MetricsConfig{Enabled: true, Listen: "127.0.0.1:9100"}).Handler()
+
+	// 配置了单独的监听地址时管理接口不再提供指标
+	if rec := get(h, "/metrics", testToken); rec.Code != http.StatusNotFound {
+		t.Errorf("状态码 = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestStatusReportsGCTotals(t *testing.T) {
+	s := newTestServer(t, config.MetricsConfig{})
+	s.cluster.Stats().RecordGC(3, 1024)
+	s.cluster.Stats().RecordGC(2, 512)
+
+	rec := get(s.Handler(), "/api/status", testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("状态码 = %d", rec.Code)
+	}
+
+	var status statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("无法解析响应: %v", err)
+	}
+	if status.GCFiles != 5 || status.GCBytes != 1536 {
+		t.Errorf("gcFiles = %d, gcBytes = %d, want 5, 1536", status.GCFiles, status.GCBytes)
+	}
+}
+
+func TestDisableIsManual(t *testing.T) {
+	s := newTestServer(t, config.MetricsConfig{})
+	h := s.Handler()
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("/api/disable"); rec.Code != http.StatusOK {
+		t.Fatalf("禁用状态码 = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !s.cluster.ManuallyDisabled() {
+		t.Error("通过管理接口禁用后应标记为手动禁用")
+	}
+
+	// 未连接中心服务器时启用失败，但手动禁用标记已被清除
+	post("/api/enable")
+	if s.cluster.ManuallyDisabled() {
+		t.Error("通过管理接口启用后应清除手动禁用标记")
+	}
+}
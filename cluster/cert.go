@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// certFileName 中心服务器签发证书的保存文件名
+	certFileName = "cert.pem"
+	// keyFileName 中心服务器签发私钥的保存文件名
+	keyFileName = "key.pem"
+	// requestCertTimeout 等待证书签发的超时时间
+	requestCertTimeout = 2 * time.Minute
+	// certRetryInterval 证书续期失败后的重试间隔
+	certRetryInterval = time.Hour
+	// certRequestAttempts 没有可用证书时最多申请几次
+	certRequestAttempts = 5
+)
+
+// certResponse 中心服务器返回的证书
+type certResponse struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// certManager 管理中心服务器签发的证书
+type certManager struct {
+	mu        sync.Mutex
+	notBefore time.Time
+	notAfter  time.Time
+	renewOnce sync.Once
+}
+
+// certPaths 返回中心服务器签发证书在磁盘上的保存路径
+func (c *Cluster) certPaths() (string, string) {
+	dir := filepath.Join(c.Config.System.DataDir, "ssl")
+	return filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName)
+}
+
+// CertFiles 返回应用于HTTPS的证书和私钥路径，未启用HTTPS时返回空字符串
+func (c *Cluster) CertFiles() (string, string) {
+	security := c.Config.Security
+	if security.SSLCert != "" && security.SSLKey != "" {
+		return security.SSLCert, security.SSLKey
+	}
+	if c.BYOC {
+		return "", ""
+	}
+
+	certFile, keyFile := c.certPaths()
+	if _, err := os.Stat(certFile); err != nil {
+		return "", ""
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return "", ""
+	}
+	return certFile, keyFile
+}
+
+// RequestCert 向中心服务器申请证书并保存到磁盘，之后会在证书过期前自动续期。
+// 申请失败时，如果磁盘上已有仍然有效的证书则继续使用，否则按退避策略重试，
+// 多次失败后返回错误。ctx取消时停止等待签发
+func (c *Cluster) RequestCert(ctx context.Context) error {
+	err := c.renewCert(ctx)
+	if err != nil {
+		certFile, keyFile := c.certPaths()
+		if leaf, loadErr := loadCertLeaf(certFile, keyFile); loadErr == nil && time.Now().Before(leaf.NotAfter) {
+			c.logger.Warn("申请证书失败，继续使用已保存的证书（有效期至 %s）: %v", leaf.NotAfter.Format(time.RFC3339), err)
+			c.certs.mu.Lock()
+			c.certs.notBefore = leaf.NotBefore
+			c.certs.notAfter = leaf.NotAfter
+			c.certs.mu.Unlock()
+		} else if err = c.retryRequestCert(ctx, err); err != nil {
+			return err
+		}
+	}
+
+	c.certs.renewOnce.Do(func() {
+		go c.certRenewLoop()
+	})
+
+	return nil
+}
+
+// retryRequestCert 没有可用证书时按退避策略重新申请，err为上一次申请的错误
+func (c *Cluster) retryRequestCert(ctx context.Context, err error) error {
+	for attempt := 1; attempt < certRequestAttempts; attempt++ {
+		c.logger.Warn("申请证书失败 (第%d次): %v", attempt, err)
+		if !certRequestBackoff.Sleep(attempt-1, ctx.Done()) {
+			return err
+		}
+		if err = c.renewCert(ctx); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("申请证书 %d 次均失败: %w", certRequestAttempts, err)
+}
+
+// renewCert 申请一次证书，验证通过后才替换磁盘上的旧证书
+func (c *Cluster) renewCert(ctx context.Context) error {
+	c.conn.mu.Lock()
+	socket := c.conn.socket
+	c.conn.mu.Unlock()
+	if socket == nil {
+		return fmt.Errorf("尚未连接到中心服务器")
+	}
+
+	c.logger.Info("正在向中心服务器申请证书...")
+
+	ctx, cancel := context.WithTimeout(ctx, requestCertTimeout)
+	defer cancel()
+
+	args, err := socket.EmitWithAck(ctx, "request-cert")
+	if err != nil {
+		return fmt.Errorf("申请证书失败: %w", err)
+	}
+	data, err := parseAck(args)
+	if err != nil {
+		return fmt.Errorf("中心服务器拒绝签发证书: %w", err)
+	}
+
+	var resp certResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("无法解析证书响应: %w", err)
+	}
+
+	// 先验证证书与私钥匹配，避免用无效证书覆盖旧证书
+	pair, err := tls.X509KeyPair([]byte(resp.Cert), []byte(resp.Key))
+	if err != nil {
+		return fmt.Errorf("中心服务器返回的证书无效: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("无法解析证书: %w", err)
+	}
+
+	certFile, keyFile := c.certPaths()
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("无法创建证书目录: %w", err)
+	}
+	// 先写私钥再写证书，证书文件的变化会触发HTTPS服务重新加载
+	if err := writeFileAtomic(keyFile, []byte(resp.Key), 0600); err != nil {
+		return fmt.Errorf("无法保存私钥: %w", err)
+	}
+	if err := writeFileAtomic(certFile, []byte(resp.Cert), 0644); err != nil {
+		return fmt.Errorf("无法保存证书: %w", err)
+	}
+
+	c.certs.mu.Lock()
+	c.certs.notBefore = leaf.NotBefore
+	c.certs.notAfter = leaf.NotAfter
+	c.certs.mu.Unlock()
+
+	c.logger.Info("已获取证书，有效期至 %s", leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// certRenewLoop 在证书有效期过去三分之二时续期，失败则定期重试并保留旧证书
+func (c *Cluster) certRenewLoop() {
+	for {
+		c.certs.mu.Lock()
+		notBefore, notAfter := c.certs.notBefore, c.certs.notAfter
+		c.certs.mu.Unlock()
+
+		renewAt := notBefore.Add(notAfter.Sub(notBefore) * 2 / 3)
+		wait := time.Until(renewAt)
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := c.renewCert(c.ctx); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.logger.Error("证书续期失败，继续使用旧证书（有效期至 %s）: %v", notAfter.Format(time.RFC3339), err)
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(certRetryInterval):
+			}
+		}
+	}
+}
+
+// loadCertLeaf 读取磁盘上的证书并返回其叶子证书
+func loadCertLeaf(certFile, keyFile string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// writeFileAtomic 先写入临时文件再重命名，避免读取到不完整的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestCertRetriesUntilIssued(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+
+	// 中心服务器先拒绝签发，之后恢复
+	center.SetCertFailures(2)
+	if err := c.RequestCert(ctx); err != nil {
+		t.Fatalf("RequestCert: %v", err)
+	}
+	if n := center.EventCount("request-cert"); n != 3 {
+		t.Errorf("收到 %d 次request-cert, want 3", n)
+	}
+	if certFile, keyFile := c.CertFiles(); certFile == "" || keyFile == "" {
+		t.Error("申请证书后 CertFiles 为空")
+	}
+
+	c.certs.mu.Lock()
+	notAfter := c.certs.notAfter
+	c.certs.mu.Unlock()
+	if notAfter.IsZero() {
+		t.Error("申请证书后未记录有效期，无法续期")
+	}
+}
+
+func TestRequestCertGivesUp(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	center.SetCertFailures(certRequestAttempts + 1)
+	if err := c.RequestCert(context.Background()); err == nil {
+		t.Fatal("中心服务器一直拒绝签发时 RequestCert 应当返回错误")
+	}
+	if n := center.EventCount("request-cert"); n != certRequestAttempts {
+		t.Errorf("收到 %d 次request-cert, want %d", n, certRequestAttempts)
+	}
+	if certFile, _ := c.CertFiles(); certFile != "" {
+		t.Errorf("CertFiles = %q, want 空", certFile)
+	}
+}
+
+func TestRequestCertRespectsContext(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	center.SetCertFailures(certRequestAttempts + 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.RequestCert(ctx); err == nil {
+		t.Fatal("ctx取消后 RequestCert 应当返回错误")
+	}
+}
+
+func TestRequestCertKeepsSavedCert(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+
+	if err := c.RequestCert(ctx); err != nil {
+		t.Fatalf("RequestCert: %v", err)
+	}
+
+	// 已保存的证书仍然有效时不重试，继续使用旧证书
+	center.SetCertFailures(1)
+	if err := c.RequestCert(ctx); err != nil {
+		t.Fatalf("RequestCert: %v", err)
+	}
+	if n := center.EventCount("request-cert"); n != 2 {
+		t.Errorf("收到 %d 次request-cert, want 2", n)
+	}
+}
@@ -1,13 +1,17 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/uright008/go-openbmclapi-reborn/config"
 	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
 	"github.com/uright008/go-openbmclapi-reborn/storage"
 	"github.com/uright008/go-openbmclapi-reborn/sync"
 	"github.com/uright008/go-openbmclapi-reborn/token"
@@ -18,6 +22,9 @@ const (
 	version = "1.14.0"
 )
 
+// dependencyCenter 中心服务器熔断器的名称，与同步管理器共用
+const dependencyCenter = sync.DependencyCenter
+
 // Cluster 结构体定义
 type Cluster struct {
 	ID         string
@@ -31,15 +38,24 @@ type Cluster struct {
 	tokenMgr   *token.TokenManager
 	syncMgr    *sync.SyncManager
 	httpClient *http.Client
-	errorMgr   *ErrorRetryManager
+	policy     *resilience.Policy
+	escalation escalation
 	logger     *logger.Logger
 	serverURL  string
+	conn       connState
+	certs      certManager
+	stats      *Stats
+	scheduler  *Scheduler
+
+	// ctx 在Close时取消，用于重连、保活和证书续期等后台操作
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewCluster 创建一个新的集群实例
 func NewCluster(cfg *config.Config, logger *logger.Logger) (*Cluster, error) {
 	// 创建存储实例
-	store, err := storage.NewStorage(cfg)
+	store, err := storage.NewStorage(cfg, logger)
 	if err != nil {
 		return nil, fmt.Errorf("无法创建存储实例: %w", err)
 	}
@@ -49,15 +65,18 @@ func NewCluster(cfg *config.Config, logger *logger.Logger) (*Cluster, error) {
 		Timeout: 30 * time.Second,
 	}
 
-	// 创建令牌管理器
-	serverURL := "https://openbmclapi.bangbang93.com" // 默认服务器URL
-	tokenMgr := token.NewTokenManager(cfg.Cluster.ID, cfg.Cluster.Secret, serverURL)
+	// 创建令牌管理器，所有中心服务器请求都使用配置的服务器地址
+	serverURL := strings.TrimSuffix(cfg.Cluster.ServerURL, "/")
+	tokenMgr := token.NewTokenManager(cfg.Cluster.ID, cfg.Cluster.Secret, serverURL, logger)
 
-	// 创建同步管理器
-	syncMgr := sync.NewSyncManager(store, tokenMgr, logger, &cfg.Sync, &cfg.Debug)
+	// 创建中心服务器与存储共用的熔断策略
+	policy := newPolicy()
 
-	// 创建错误重试管理器
-	errorMgr := NewErrorRetryManager(5, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 创建同步管理器
+	statePath := filepath.Join(cfg.System.DataDir, "sync_state.json")
+	syncMgr := sync.NewSyncManager(store, tokenMgr, logger, serverURL, policy, &cfg.Sync, &cfg.Debug, statePath)
 
 	cluster := &Cluster{
 		ID:         cfg.Cluster.ID,
@@ -71,27 +90,39 @@ func NewCluster(cfg *config.Config, logger *logger.Logger) (*Cluster, error) {
 		tokenMgr:   tokenMgr,
 		syncMgr:    syncMgr,
 		httpClient: client,
-		errorMgr:   errorMgr,
+		policy:     policy,
 		logger:     logger,
 		serverURL:  serverURL,
+		stats:      NewStats(),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 
+	policy.OnStateChange(cluster.onBreakerChange)
+
+	cluster.scheduler = NewScheduler(
+		cluster,
+		time.Duration(cfg.Sync.IntervalMinutes)*time.Minute,
+		cfg.Sync.DisableThreshold,
+		logger,
+	)
+
 	return cluster, nil
 }
 
-// doRequest 执行HTTP请求的统一方法
-func (c *Cluster) doRequest(method, path string, params map[string]string) (*http.Response, error) {
+// doRequest 执行HTTP请求的统一方法，请求遵循ctx的取消和超时
+func (c *Cluster) doRequest(ctx context.Context, method, path string, params map[string]string) (*http.Response, error) {
 	// 构建完整URL
 	url := fmt.Sprintf("%s/%s", c.serverURL, path)
 
 	// 创建请求
-	req, err := http.NewRequest(method, url, nil)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("无法创建请求: %w", err)
 	}
 
 	// 获取认证令牌
-	token, err := c.tokenMgr.GetToken()
+	token, err := c.tokenMgr.GetToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("无法获取认证令牌: %w", err)
 	}
@@ -122,6 +153,11 @@ func (c *Cluster) doRequest(method, path string, params map[string]string) (*htt
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
 
+	// 令牌被拒绝时使其失效，后续请求会重新认证
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.tokenMgr.Invalidate(token)
+	}
+
 	// 检查响应状态
 	if resp.StatusCode >= 400 {
 		// 读取响应体以便记录错误详情
@@ -144,96 +180,155 @@ func (c *Cluster) doRequest(method, path string, params map[string]string) (*htt
 }
 
 // Init 初始化集群
-func (c *Cluster) Init() error {
+func (c *Cluster) Init(ctx context.Context) error {
 	// 初始化存储
-	err := c.Storage.Init()
+	err := c.Storage.Init(ctx)
 	if err != nil {
-		c.errorMgr.RecordError(fmt.Errorf("存储初始化失败: %w", err))
 		return fmt.Errorf("存储初始化失败: %w", err)
 	}
 
 	// 检查存储是否可用
-	ready, err := c.Storage.Check()
+	ready, err := c.Storage.Check(ctx)
 	if err != nil {
-		c.errorMgr.RecordError(fmt.Errorf("存储检查失败: %w", err))
 		return fmt.Errorf("存储检查失败: %w", err)
 	}
 	if !ready {
-		err := fmt.Errorf("存储不可用")
-		c.errorMgr.RecordError(err)
-		return err
+		return fmt.Errorf("存储不可用")
 	}
 
-	// 初始化成功，重置错误计数
-	c.errorMgr.ResetErrors()
 	return nil
 }
 
-// Connect 连接到中心服务器
-func (c *Cluster) Connect() error {
+// Connect 连接到中心服务器，ctx取消时中止连接
+func (c *Cluster) Connect(ctx context.Context) error {
 	c.logger.Info("连接到中心服务器...")
 
-	// 获取认证令牌
-	_, err := c.tokenMgr.GetToken()
+	socket, err := c.dial(ctx)
 	if err != nil {
-		c.errorMgr.RecordError(fmt.Errorf("无法获取认证令牌: %w", err))
-		return fmt.Errorf("无法获取认证令牌: %w", err)
+		return fmt.Errorf("无法连接到中心服务器: %w", err)
 	}
 
+	c.conn.mu.Lock()
+	c.conn.closing = false
+	c.conn.socket = socket
+	c.conn.mu.Unlock()
+
 	c.logger.Info("成功连接到中心服务器")
-	// 连接成功，重置错误计数
-	c.errorMgr.ResetErrors()
 	return nil
 }
 
-// SyncFiles 同步文件
-func (c *Cluster) SyncFiles() error {
+// SyncFiles 同步文件，ctx取消时同步中止
+func (c *Cluster) SyncFiles(ctx context.Context) error {
 	c.logger.Info("开始同步文件...")
 
-	err := c.syncMgr.SyncFiles()
+	err := c.scheduler.RunOnce(ctx)
 	if err != nil {
-		c.errorMgr.RecordError(fmt.Errorf("文件同步失败: %w", err))
 		return fmt.Errorf("文件同步失败: %w", err)
 	}
 
 	c.logger.Info("文件同步完成")
-	// 同步成功，重置错误计数
-	c.errorMgr.ResetErrors()
 	return nil
 }
 
-// Close 关闭集群
-func (c *Cluster) Close() error {
+// collectGarbage 根据完整文件列表清理存储，并记录回收的文件数与字节数
+func (c *Cluster) collectGarbage(ctx context.Context, plan *sync.SyncPlan, dryRun bool) (*storage.GCResult, error) {
+	opts := storage.GCOptions{
+		DryRun:         dryRun,
+		MaxDeleteRatio: c.Config.GC.MaxDeleteRatio,
+	}
+
+	result, err := c.syncMgr.CollectGarbage(ctx, plan, opts)
+	if err != nil {
+		return result, err
+	}
+
+	if result.DryRun {
+		c.logger.Info("垃圾回收(dry-run): 扫描 %d 个文件, 将删除 %d 个文件, 可回收 %s",
+			result.Scanned, result.Deleted, c.logger.FormatBytes(result.Bytes))
+		return result, nil
+	}
+
+	c.stats.RecordGC(result.Deleted, result.Bytes)
+	c.logger.Info("垃圾回收完成: 扫描 %d 个文件, 删除 %d 个文件, 回收 %s, 失败 %d",
+		result.Scanned, result.Deleted, c.logger.FormatBytes(result.Bytes), result.Failed)
+	return result, nil
+}
+
+// Scheduler 返回定期同步调度器
+func (c *Cluster) Scheduler() *Scheduler {
+	return c.scheduler
+}
+
+// Close 关闭集群，禁用节点时遵循ctx的期限
+func (c *Cluster) Close(ctx context.Context) error {
 	c.logger.Info("关闭集群...")
 
-	// 清理资源逻辑将在这里实现
+	// 停止定期同步
+	c.scheduler.Stop()
 
-	return nil
+	// 停止重连、保活和证书续期
+	c.cancel()
+
+	// 先禁用节点，避免中心服务器继续分配流量
+	err := c.Disable(ctx)
+	if err != nil {
+		c.logger.Error("禁用节点失败: %v", err)
+	}
+
+	c.conn.mu.Lock()
+	c.conn.closing = true
+	socket := c.conn.socket
+	c.conn.socket = nil
+	c.conn.mu.Unlock()
+
+	if socket != nil {
+		socket.Close()
+	}
+
+	// 停止令牌刷新
+	c.tokenMgr.Close()
+
+	return err
+}
+
+// Stats 返回节点的请求与流量统计
+func (c *Cluster) Stats() *Stats {
+	return c.stats
+}
+
+// RecordHit 记录一次下载请求及其传输的字节数
+func (c *Cluster) RecordHit(bytes int64) {
+	c.stats.RecordHit(bytes)
+}
+
+// FileSize 返回已知文件的大小，用于统计重定向请求的流量
+func (c *Cluster) FileSize(hash string) (int64, bool) {
+	return c.syncMgr.FileSize(hash)
+}
+
+// MissingFiles 返回存储中相对完整文件列表缺失的文件
+func (c *Cluster) MissingFiles(ctx context.Context) ([]*storage.FileInfo, error) {
+	return c.syncMgr.MissingFiles(ctx)
 }
 
 // GetFileList 从中心服务器获取文件列表
-func (c *Cluster) GetFileList() error {
+func (c *Cluster) GetFileList(ctx context.Context) error {
 	// 设置查询参数
 	params := map[string]string{}
 
 	// 发送请求
-	resp, err := c.doRequest("GET", "openbmclapi/files", params)
+	resp, err := c.doRequest(ctx, "GET", "openbmclapi/files", params)
 	if err != nil {
-		c.errorMgr.RecordError(fmt.Errorf("无法获取文件列表: %w", err))
 		return fmt.Errorf("无法获取文件列表: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("获取文件列表失败，状态码: %d", resp.StatusCode)
-		c.errorMgr.RecordError(err)
-		return err
+		return fmt.Errorf("获取文件列表失败，状态码: %d", resp.StatusCode)
 	}
 
 	// 处理响应将在后续实现
 	c.logger.Info("成功获取文件列表")
 
-	// 操作成功，重置错误计数
-	c.errorMgr.ResetErrors()
 	return nil
 }
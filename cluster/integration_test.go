@@ -0,0 +1,197 @@
+//go:build integration
+
+package cluster_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/cluster"
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/mockcenter"
+	"github.com/uright008/go-openbmclapi-reborn/server"
+	"github.com/uright008/go-openbmclapi-reborn/utils"
+)
+
+// TestStartup 按main的顺序走完启动流程：申请证书、同步文件、启用节点、保活上报、禁用并关闭。
+// 保活和重连间隔由lifecycle_test.go中的TestMain缩短
+func TestStartup(t *testing.T) {
+	const (
+		clusterID = "integration-cluster"
+		secret    = "integration-secret"
+	)
+
+	center := mockcenter.NewServer(clusterID, secret)
+	defer center.Close()
+	hashA := center.AddFile([]byte("file a"), 1000)
+	hashB := center.AddFile([]byte("file b"), 2000)
+
+	cfg := &config.Config{
+		Cluster: config.ClusterConfig{
+			ID:         clusterID,
+			Secret:     secret,
+			IP:         "127.0.0.1",
+			Port:       4000,
+			PublicPort: 4000,
+			ServerURL:  center.URL,
+		},
+		Storage: config.StorageConfig{Type: "file", Path: t.TempDir()},
+		System:  config.SystemConfig{DataDir: t.TempDir()},
+		Sync: config.SyncConfig{
+			MaxConcurrency:   4,
+			IntervalMinutes:  10,
+			DisableThreshold: 100,
+		},
+	}
+
+	c, err := cluster.NewCluster(cfg, logger.New(false))
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	// 中心服务器签发的证书写入数据目录
+	if err := c.RequestCert(ctx); err != nil {
+		t.Fatalf("RequestCert: %v", err)
+	}
+	certFile, keyFile := c.CertFiles()
+	if certFile == "" || keyFile == "" {
+		t.Fatal("申请证书后 CertFiles 为空")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("无法加载签发的证书: %v", err)
+	}
+
+	// 首次同步下载全部文件并保存水位
+	if err := c.SyncFiles(ctx); err != nil {
+		t.Fatalf("SyncFiles: %v", err)
+	}
+	for hash, content := range map[string]string{hashA: "file a", hashB: "file b"} {
+		if got := readStored(t, c, hash); got != content {
+			t.Errorf("%s 的内容 = %q, want %q", hash, got, content)
+		}
+	}
+	if got := readWatermark(t, cfg.System.DataDir); got != 2000 {
+		t.Errorf("首次同步后水位 = %d, want 2000", got)
+	}
+
+	// 再次同步只下载水位之后变化的文件
+	hashC := center.AddFile([]byte("file c"), 3000)
+	if err := c.SyncFiles(ctx); err != nil {
+		t.Fatalf("SyncFiles: %v", err)
+	}
+	downloads := center.Downloads()
+	if len(downloads) != 3 || downloads[2] != hashC {
+		t.Errorf("下载记录 = %v, 第二次同步应只下载 %s", downloads, hashC)
+	}
+	if got := readWatermark(t, cfg.System.DataDir); got != 3000 {
+		t.Errorf("增量同步后水位 = %d, want 3000", got)
+	}
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if !center.Enabled() {
+		t.Fatal("启用后中心服务器上节点未启用")
+	}
+
+	// 通过HTTP服务器下载文件，下载计数随保活上报
+	ts := httptest.NewServer(server.NewServer(c, logger.New(false)).SetupRoutes())
+	defer ts.Close()
+
+	sign, expire := utils.SignDownload(secret, hashA, time.Now().Add(time.Hour))
+	resp, err := http.Get(ts.URL + "/download/" + hashA + "?" + url.Values{"s": {sign}, "e": {expire}}.Encode())
+	if err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "file a" {
+		t.Fatalf("下载 = %d %q", resp.StatusCode, body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var hits, bytes int64
+		for _, keepAlive := range center.KeepAlives() {
+			hits += keepAlive.Hits
+			bytes += keepAlive.Bytes
+		}
+		if hits == 1 && bytes == int64(len("file a")) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("保活上报 = %d 次下载, %d 字节, want 1, %d", hits, bytes, len("file a"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := c.Disable(ctx); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if center.Enabled() {
+		t.Error("禁用后中心服务器上节点仍处于启用状态")
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// 控制通道上的事件按启动顺序出现
+	events := center.Events()
+	last := -1
+	for _, event := range []string{"request-cert", "enable", "keep-alive", "disable"} {
+		i := slices.Index(events, event)
+		if i <= last {
+			t.Fatalf("事件顺序 = %v, %s 缺失或顺序错误", events, event)
+		}
+		last = i
+	}
+}
+
+// readStored 读取存储中的文件内容
+func readStored(t *testing.T, c *cluster.Cluster, hash string) string {
+	t.Helper()
+	reader, err := c.Storage.Get(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("Get %s: %v", hash, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("读取 %s: %v", hash, err)
+	}
+	return string(data)
+}
+
+// readWatermark 读取数据目录中保存的同步水位
+func readWatermark(t *testing.T, dataDir string) int64 {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dataDir, "sync_state.json"))
+	if err != nil {
+		t.Fatalf("无法读取同步状态: %v", err)
+	}
+	var state struct {
+		LastModified int64 `json:"lastModified"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("无法解析同步状态: %v", err)
+	}
+	return state.LastModified
+}
@@ -0,0 +1,406 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keepAliveInterval 保活上报间隔
+var keepAliveInterval = 60 * time.Second
+
+const (
+	// keepAliveTimeout 等待保活确认的超时时间
+	keepAliveTimeout = 10 * time.Second
+	// maxKeepAliveFailures 连续保活失败多少次后重新连接
+	maxKeepAliveFailures = 3
+	// enableTimeout 等待启用确认的超时时间，中心服务器会在此期间测速
+	enableTimeout = 5 * time.Minute
+	// connectTimeout 建立Socket.IO连接的超时时间
+	connectTimeout = 30 * time.Second
+)
+
+// connState 保存与中心服务器之间Socket.IO连接的状态
+type connState struct {
+	mu            sync.Mutex
+	socket        *socketClient
+	enabled       bool
+	wantEnabled   bool
+	closing       bool
+	stopKeepAlive chan struct{}
+	// cancelEnable 中止正在等待确认的enable请求，没有进行中的请求时为nil
+	cancelEnable context.CancelFunc
+
+	// transition 串行化启用与禁用，同一时间只有一个请求在与中心服务器交互
+	transition sync.Mutex
+
+	// manualDisabled 节点由运维人员禁用，熔断恢复和同步不会自动重新启用
+	manualDisabled atomic.Bool
+}
+
+// enableRequest 启用节点时上报的信息
+type enableRequest struct {
+	Host         string        `json:"host"`
+	Port         int           `json:"port"`
+	Version      string        `json:"version"`
+	BYOC         bool          `json:"byoc"`
+	NoFastEnable bool          `json:"noFastEnable"`
+	Flavor       clusterFlavor `json:"flavor"`
+}
+
+// clusterFlavor 节点运行环境信息
+type clusterFlavor struct {
+	Runtime string `json:"runtime"`
+	Storage string `json:"storage"`
+}
+
+// keepAliveRequest 保活上报的信息
+type keepAliveRequest struct {
+	Time  time.Time `json:"time"`
+	Hits  int64     `json:"hits"`
+	Bytes int64     `json:"bytes"`
+}
+
+// dial 建立到中心服务器的Socket.IO连接并注册服务器事件，连接过程遵循ctx的取消
+func (c *Cluster) dial(ctx context.Context) (*socketClient, error) {
+	breaker := c.policy.Breaker(dependencyCenter)
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	token, err := c.tokenMgr.GetToken(ctx)
+	if err != nil {
+		breaker.Failure(err)
+		return nil, fmt.Errorf("无法获取认证令牌: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", fmt.Sprintf("openbmclapi-cluster/%s", version))
+
+	socket, err := dialSocket(ctx, c.serverURL, map[string]string{"token": token}, header)
+	if err != nil {
+		// 中心服务器拒绝连接通常是令牌失效，重连前重新认证
+		if errors.Is(err, errConnectRejected) {
+			c.tokenMgr.Invalidate(token)
+		}
+		breaker.Failure(err)
+		return nil, err
+	}
+	breaker.Success()
+
+	socket.On("message", func(args []json.RawMessage) {
+		c.logger.Info("[中心服务器] %s", formatSocketArgs(args))
+	})
+	socket.On("exception", func(args []json.RawMessage) {
+		c.logger.Error("[中心服务器] 异常: %s", formatSocketArgs(args))
+	})
+	socket.On("warden-error", func(args []json.RawMessage) {
+		c.logger.Warn("[中心服务器] 巡检错误: %s", formatSocketArgs(args))
+	})
+
+	go c.watchSocket(socket)
+
+	return socket, nil
+}
+
+// Enable 向中心服务器发送enable事件，使节点开始接收流量。ctx取消或Disable被调用时停止等待确认
+func (c *Cluster) Enable(ctx context.Context) error {
+	c.conn.transition.Lock()
+	defer c.conn.transition.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, enableTimeout)
+	defer cancel()
+
+	c.conn.mu.Lock()
+	socket := c.conn.socket
+	if socket == nil {
+		c.conn.mu.Unlock()
+		return fmt.Errorf("尚未连接到中心服务器")
+	}
+	if c.conn.enabled {
+		c.conn.mu.Unlock()
+		return nil
+	}
+	c.conn.wantEnabled = true
+	c.conn.cancelEnable = cancel
+	c.conn.mu.Unlock()
+
+	defer func() {
+		c.conn.mu.Lock()
+		c.conn.cancelEnable = nil
+		c.conn.mu.Unlock()
+	}()
+
+	req := enableRequest{
+		Host:         c.IP,
+		Port:         c.PublicPort,
+		Version:      version,
+		BYOC:         c.BYOC,
+		NoFastEnable: false,
+		Flavor: clusterFlavor{
+			Runtime: fmt.Sprintf("golang/%s", runtime.Version()),
+			Storage: c.Config.Storage.Type,
+		},
+	}
+
+	c.logger.Info("正在启用节点...")
+
+	args, err := socket.EmitWithAck(ctx, "enable", req)
+	if err != nil {
+		return fmt.Errorf("启用节点失败: %w", err)
+	}
+	if _, err := parseAck(args); err != nil {
+		return fmt.Errorf("中心服务器拒绝启用节点: %w", err)
+	}
+
+	c.conn.mu.Lock()
+	if c.conn.socket != socket {
+		c.conn.mu.Unlock()
+		return fmt.Errorf("启用节点期间与中心服务器的连接已断开")
+	}
+	// 理论上此时不会有保活循环在运行，仍先停止以免重复启动
+	c.stopKeepAliveLocked()
+	c.conn.enabled = true
+	stop := make(chan struct{})
+	c.conn.stopKeepAlive = stop
+	c.conn.mu.Unlock()
+
+	go c.keepAliveLoop(socket, stop)
+
+	c.logger.Info("节点已启用")
+	return nil
+}
+
+// Disable 向中心服务器发送disable事件，使节点停止接收流量。
+// 正在等待确认的Enable会被中止，ctx取消时停止等待确认
+func (c *Cluster) Disable(ctx context.Context) error {
+	c.conn.mu.Lock()
+	c.conn.wantEnabled = false
+	pending := c.conn.cancelEnable != nil
+	if pending {
+		c.conn.cancelEnable()
+	}
+	c.conn.mu.Unlock()
+
+	// 等待被中止的Enable返回
+	c.conn.transition.Lock()
+	defer c.conn.transition.Unlock()
+
+	c.conn.mu.Lock()
+	socket := c.conn.socket
+	wasEnabled := c.conn.enabled
+	c.stopKeepAliveLocked()
+	c.conn.mu.Unlock()
+
+	// 被中止的enable可能已被中心服务器处理，此时同样需要发送disable
+	if (!wasEnabled && !pending) || socket == nil {
+		return nil
+	}
+
+	c.logger.Info("正在禁用节点...")
+
+	ctx, cancel := context.WithTimeout(ctx, keepAliveTimeout)
+	defer cancel()
+
+	args, err := socket.EmitWithAck(ctx, "disable")
+	if err != nil {
+		return fmt.Errorf("禁用节点失败: %w", err)
+	}
+	if _, err := parseAck(args); err != nil {
+		return fmt.Errorf("中心服务器返回禁用错误: %w", err)
+	}
+
+	c.logger.Info("节点已禁用")
+	return nil
+}
+
+// IsEnabled 返回节点当前是否已启用
+func (c *Cluster) IsEnabled() bool {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	return c.conn.enabled
+}
+
+// ManualEnable 由运维人员启用节点，清除手动禁用标记
+func (c *Cluster) ManualEnable(ctx context.Context) error {
+	c.conn.manualDisabled.Store(false)
+	return c.Enable(ctx)
+}
+
+// ManualDisable 由运维人员禁用节点，之后只有ManualEnable会重新启用节点
+func (c *Cluster) ManualDisable(ctx context.Context) error {
+	c.conn.manualDisabled.Store(true)
+	return c.Disable(ctx)
+}
+
+// ManuallyDisabled 返回节点是否由运维人员禁用
+func (c *Cluster) ManuallyDisabled() bool {
+	return c.conn.manualDisabled.Load()
+}
+
+// stopKeepAliveLocked 停止保活循环并标记节点为禁用，调用方需持有c.conn.mu
+func (c *Cluster) stopKeepAliveLocked() {
+	c.conn.enabled = false
+	if c.conn.stopKeepAlive != nil {
+		close(c.conn.stopKeepAlive)
+		c.conn.stopKeepAlive = nil
+	}
+}
+
+// keepAliveLoop 定期上报保活信息，直到节点被禁用或连接断开
+func (c *Cluster) keepAliveLoop(socket *socketClient, stop <-chan struct{}) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-socket.Done():
+			return
+		case <-ticker.C:
+		}
+
+		kicked, err := c.keepAlive(socket)
+		if kicked {
+			c.logger.Error("节点被中心服务器踢出，正在重新连接")
+			socket.Close()
+			return
+		}
+		if err != nil {
+			failures++
+			c.logger.Error("保活失败 (%d/%d): %v", failures, maxKeepAliveFailures, err)
+			if failures >= maxKeepAliveFailures {
+				c.logger.Error("连续保活失败，正在重新连接")
+				socket.Close()
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// keepAlive 发送一次保活信息，返回节点是否已被中心服务器踢出
+func (c *Cluster) keepAlive(socket *socketClient) (bool, error) {
+	snap := c.stats.Snapshot()
+
+	ctx, cancel := context.WithTimeout(c.ctx, keepAliveTimeout)
+	defer cancel()
+
+	args, err := socket.EmitWithAck(ctx, "keep-alive", keepAliveRequest{
+		Time:  time.Now(),
+		Hits:  snap.Hits,
+		Bytes: snap.Bytes,
+	})
+	if err != nil {
+		c.stats.Rollback(snap)
+		return false, err
+	}
+
+	data, err := parseAck(args)
+	if err != nil {
+		c.stats.Rollback(snap)
+		return false, err
+	}
+
+	// 中心服务器返回false表示节点已被踢出，本次计数未被接受
+	if len(data) == 0 || string(data) == "false" || string(data) == "null" {
+		c.stats.Rollback(snap)
+		return true, nil
+	}
+
+	c.logger.Info("保活成功: 请求 %d 次, 流量 %s", snap.Hits, c.logger.FormatBytes(snap.Bytes))
+	return false, nil
+}
+
+// watchSocket 等待连接断开，若非主动关闭则自动重连
+func (c *Cluster) watchSocket(socket *socketClient) {
+	<-socket.Done()
+
+	c.conn.mu.Lock()
+	if c.conn.socket != socket {
+		c.conn.mu.Unlock()
+		return
+	}
+	c.conn.socket = nil
+	c.stopKeepAliveLocked()
+	closing := c.conn.closing
+	c.conn.mu.Unlock()
+
+	if closing {
+		return
+	}
+
+	c.logger.Warn("与中心服务器的连接已断开: %s", socket.Reason())
+	c.reconnect()
+}
+
+// reconnect 按指数退避重新连接中心服务器，节点应处于启用状态时重新启用，集群关闭时停止
+func (c *Cluster) reconnect() {
+	for attempt := 0; ; attempt++ {
+		if !reconnectBackoff.Sleep(attempt, c.ctx.Done()) {
+			return
+		}
+
+		c.conn.mu.Lock()
+		closing := c.conn.closing
+		c.conn.mu.Unlock()
+		if closing {
+			return
+		}
+
+		c.logger.Info("正在重新连接中心服务器...")
+		socket, err := c.dial(c.ctx)
+		if err == nil {
+			c.conn.mu.Lock()
+			c.conn.socket = socket
+			wantEnabled := c.conn.wantEnabled
+			c.conn.mu.Unlock()
+
+			if !wantEnabled {
+				c.logger.Info("已重新连接到中心服务器")
+				return
+			}
+			if err = c.Enable(c.ctx); err == nil {
+				return
+			}
+
+			// 启用期间节点被禁用时保留连接，不再重试
+			c.conn.mu.Lock()
+			if !c.conn.wantEnabled && c.conn.socket == socket {
+				c.conn.mu.Unlock()
+				c.logger.Info("已重新连接到中心服务器")
+				return
+			}
+			// 先解除关联，避免watchSocket再次触发重连
+			if c.conn.socket == socket {
+				c.conn.socket = nil
+			}
+			c.conn.mu.Unlock()
+			socket.Close()
+		}
+
+		c.logger.Error("重新连接失败: %v", err)
+	}
+}
+
+// formatSocketArgs 将事件参数格式化为便于阅读的字符串
+func formatSocketArgs(args []json.RawMessage) string {
+	if len(args) == 1 {
+		var str string
+		if err := json.Unmarshal(args[0], &str); err == nil {
+			return str
+		}
+		return string(args[0])
+	}
+	data, _ := json.Marshal(args)
+	return string(data)
+}
@@ -0,0 +1,377 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/mockcenter"
+)
+
+const (
+	testClusterID     = "test-cluster"
+	testClusterSecret = "test-secret"
+)
+
+func TestMain(m *testing.M) {
+	// 缩短保活、重连和证书申请的重试间隔，使测试在秒级内完成
+	keepAliveInterval = 50 * time.Millisecond
+	reconnectBackoff.Base = 10 * time.Millisecond
+	reconnectBackoff.Max = 50 * time.Millisecond
+	certRequestBackoff.Base = 10 * time.Millisecond
+	certRequestBackoff.Max = 50 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// newTestCluster 创建连接到模拟中心服务器的集群，使用临时目录作为文件存储
+func newTestCluster(t *testing.T, center *mockcenter.Server) *Cluster {
+	t.Helper()
+
+	cfg := &config.Config{
+		Cluster: config.ClusterConfig{
+			ID:         testClusterID,
+			Secret:     testClusterSecret,
+			IP:         "127.0.0.1",
+			Port:       4000,
+			PublicPort: 4000,
+			ServerURL:  center.URL,
+		},
+		Storage: config.StorageConfig{Type: "file", Path: t.TempDir()},
+		System:  config.SystemConfig{DataDir: t.TempDir()},
+		Sync: config.SyncConfig{
+			MaxConcurrency:   4,
+			IntervalMinutes:  10,
+			DisableThreshold: 100,
+		},
+	}
+
+	c, err := NewCluster(cfg, logger.New(false))
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.Close(ctx)
+	})
+	return c
+}
+
+// newConnectedCluster 创建模拟中心服务器和已连接的集群
+func newConnectedCluster(t *testing.T) (*Cluster, *mockcenter.Server) {
+	t.Helper()
+
+	center := mockcenter.NewServer(testClusterID, testClusterSecret)
+	t.Cleanup(center.Close)
+
+	c := newTestCluster(t, center)
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return c, center
+}
+
+// waitFor 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if !c.IsEnabled() || !center.Enabled() {
+		t.Fatalf("启用后 IsEnabled = %v, 中心服务器 = %v", c.IsEnabled(), center.Enabled())
+	}
+
+	requests := center.EnableRequests()
+	if len(requests) != 1 {
+		t.Fatalf("收到 %d 个enable请求, want 1", len(requests))
+	}
+	var req enableRequest
+	if err := json.Unmarshal(requests[0], &req); err != nil {
+		t.Fatalf("无法解析enable请求: %v", err)
+	}
+	if req.Host != "127.0.0.1" || req.Port != 4000 || req.Version != version || req.Flavor.Storage != "file" {
+		t.Errorf("enable请求 = %+v", req)
+	}
+
+	// 已启用时再次启用不会重复发送
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if n := center.EventCount("enable"); n != 1 {
+		t.Errorf("收到 %d 次enable, want 1", n)
+	}
+
+	if err := c.Disable(ctx); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if c.IsEnabled() || center.Enabled() {
+		t.Fatalf("禁用后 IsEnabled = %v, 中心服务器 = %v", c.IsEnabled(), center.Enabled())
+	}
+
+	// 未启用时禁用不会发送disable
+	if err := c.Disable(ctx); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if n := center.EventCount("disable"); n != 1 {
+		t.Errorf("收到 %d 次disable, want 1", n)
+	}
+}
+
+func TestEnableWithoutConnection(t *testing.T) {
+	center := mockcenter.NewServer(testClusterID, testClusterSecret)
+	defer center.Close()
+
+	c := newTestCluster(t, center)
+	if err := c.Enable(context.Background()); err == nil {
+		t.Fatal("未连接时 Enable 应当失败")
+	}
+}
+
+func TestKeepAliveReportsHits(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	if err := c.Enable(context.Background()); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	c.RecordHit(100)
+	c.RecordHit(200)
+
+	var hits, bytes int64
+	waitFor(t, "保活上报", func() bool {
+		hits, bytes = 0, 0
+		for _, keepAlive := range center.KeepAlives() {
+			hits += keepAlive.Hits
+			bytes += keepAlive.Bytes
+		}
+		return hits == 2
+	})
+	if bytes != 300 {
+		t.Errorf("上报流量 = %d, want 300", bytes)
+	}
+	if pending := c.Stats().Pending(); pending.Hits != 0 || pending.Bytes != 0 {
+		t.Errorf("上报成功后仍有未上报的计数: %+v", pending)
+	}
+}
+
+func TestConcurrentEnable(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	center.SetEnableDelay(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Enable(context.Background()); err != nil {
+				t.Errorf("Enable: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := center.EventCount("enable"); n != 1 {
+		t.Fatalf("并发启用发送了 %d 次enable, want 1", n)
+	}
+
+	if err := c.Disable(context.Background()); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+
+	// 禁用后不应再有保活循环在运行
+	time.Sleep(3 * keepAliveInterval)
+	before := center.EventCount("keep-alive")
+	time.Sleep(3 * keepAliveInterval)
+	if after := center.EventCount("keep-alive"); after != before {
+		t.Errorf("禁用后仍在发送保活: %d -> %d", before, after)
+	}
+}
+
+func TestDisableCancelsPendingEnable(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	center.SetEnableDelay(time.Minute)
+
+	enableErr := make(chan error, 1)
+	go func() {
+		enableErr <- c.Enable(context.Background())
+	}()
+	waitFor(t, "enable请求", func() bool { return center.EventCount("enable") == 1 })
+
+	start := time.Now()
+	if err := c.Disable(context.Background()); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Disable 等待了 %v", elapsed)
+	}
+
+	if err := <-enableErr; err == nil {
+		t.Error("被禁用中止的 Enable 应当返回错误")
+	}
+	if c.IsEnabled() || center.Enabled() {
+		t.Errorf("禁用后 IsEnabled = %v, 中心服务器 = %v", c.IsEnabled(), center.Enabled())
+	}
+	// 被中止的enable可能已被中心服务器处理，需要发送disable
+	if n := center.EventCount("disable"); n != 1 {
+		t.Errorf("收到 %d 次disable, want 1", n)
+	}
+}
+
+func TestEnableRespectsContext(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	center.SetEnableDelay(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Enable(ctx); err == nil {
+		t.Fatal("ctx超时后 Enable 应当返回错误")
+	}
+	if c.IsEnabled() {
+		t.Error("Enable 失败后节点不应处于启用状态")
+	}
+}
+
+func TestKickedNodeReconnects(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	if err := c.Enable(context.Background()); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// 被踢出后保活会失败，节点应重新连接并重新启用
+	center.Kick()
+	waitFor(t, "重新启用", func() bool {
+		return center.EventCount("enable") >= 2 && center.Enabled() && c.IsEnabled()
+	})
+}
+
+func TestServerDisconnectReconnects(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	if err := c.Enable(context.Background()); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	center.Disconnect()
+	waitFor(t, "重新启用", func() bool {
+		return center.EventCount("enable") >= 2 && center.Enabled() && c.IsEnabled()
+	})
+}
+
+func TestDisconnectWhileDisabledStaysDisabled(t *testing.T) {
+	c, center := newConnectedCluster(t)
+
+	center.Disconnect()
+	waitFor(t, "重新连接", func() bool {
+		c.conn.mu.Lock()
+		defer c.conn.mu.Unlock()
+		return c.conn.socket != nil
+	})
+
+	if n := center.EventCount("enable"); n != 0 {
+		t.Errorf("未启用的节点重连后发送了 %d 次enable", n)
+	}
+}
+
+func TestCloseDisablesNode(t *testing.T) {
+	center := mockcenter.NewServer(testClusterID, testClusterSecret)
+	defer center.Close()
+
+	c := newTestCluster(t, center)
+	ctx := context.Background()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := center.EventCount("disable"); n != 1 {
+		t.Errorf("关闭时收到 %d 次disable, want 1", n)
+	}
+	if center.Enabled() {
+		t.Error("关闭后中心服务器上节点仍处于启用状态")
+	}
+}
+
+func TestManualDisableSurvivesBreakerRecovery(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// 熔断禁用的节点在依赖恢复后重新启用
+	c.escalate()
+	if c.IsEnabled() {
+		t.Fatal("熔断后节点仍处于启用状态")
+	}
+	c.recoverFromBreaker()
+	if !c.IsEnabled() {
+		t.Fatal("依赖恢复后节点未重新启用")
+	}
+
+	// 熔断期间被手动禁用的节点在依赖恢复后保持禁用
+	c.escalate()
+	if err := c.ManualDisable(ctx); err != nil {
+		t.Fatalf("ManualDisable: %v", err)
+	}
+	c.recoverFromBreaker()
+	if c.IsEnabled() || center.Enabled() {
+		t.Fatal("手动禁用的节点被熔断恢复重新启用")
+	}
+
+	if err := c.ManualEnable(ctx); err != nil {
+		t.Fatalf("ManualEnable: %v", err)
+	}
+	if !c.IsEnabled() || c.ManuallyDisabled() {
+		t.Errorf("手动启用后 IsEnabled = %v, ManuallyDisabled = %v", c.IsEnabled(), c.ManuallyDisabled())
+	}
+}
+
+func TestManualDisableSurvivesSync(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+	center.AddFile([]byte("file"), 1000)
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// 同步禁用节点后运维人员手动禁用，同步完成后不应重新启用
+	c.Scheduler().disabledBySync.Store(true)
+	if err := c.ManualDisable(ctx); err != nil {
+		t.Fatalf("ManualDisable: %v", err)
+	}
+	if err := c.Scheduler().RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	if c.IsEnabled() || center.Enabled() {
+		t.Error("手动禁用的节点被同步重新启用")
+	}
+	if n := center.EventCount("enable"); n != 1 {
+		t.Errorf("收到 %d 次enable, want 1", n)
+	}
+}
@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
+	"github.com/uright008/go-openbmclapi-reborn/token"
+)
+
+const (
+	// breakerThreshold 依赖连续失败多少次后熔断
+	breakerThreshold = 5
+)
+
+var (
+	// retryBackoff 下载等请求失败后的重试退避策略
+	retryBackoff = resilience.Backoff{
+		Base:   time.Second,
+		Max:    30 * time.Second,
+		Factor: 2,
+		Jitter: 0.2,
+	}
+	// breakerCooldown 熔断后的冷却时间，探测失败时逐次加长
+	breakerCooldown = resilience.Backoff{
+		Base:   30 * time.Second,
+		Max:    10 * time.Minute,
+		Factor: 2,
+		Jitter: 0.1,
+	}
+	// reconnectBackoff 与中心服务器断线后的重连退避策略
+	reconnectBackoff = resilience.Backoff{
+		Base:   5 * time.Second,
+		Max:    5 * time.Minute,
+		Factor: 2,
+		Jitter: 0.2,
+	}
+	// certRequestBackoff 没有可用证书时申请证书的重试退避策略
+	certRequestBackoff = resilience.Backoff{
+		Base:   5 * time.Second,
+		Max:    time.Minute,
+		Factor: 2,
+		Jitter: 0.2,
+	}
+)
+
+// escalation 记录节点是否因依赖熔断而被禁用
+type escalation struct {
+	mu       sync.Mutex
+	disabled bool
+}
+
+// newPolicy 创建中心服务器与存储共用的熔断策略
+func newPolicy() *resilience.Policy {
+	return resilience.NewPolicy(retryBackoff, breakerThreshold, breakerCooldown)
+}
+
+// onBreakerChange 熔断器状态变化时禁用或重新启用节点，从不退出进程
+func (c *Cluster) onBreakerChange(name string, from, to resilience.State) {
+	switch to {
+	case resilience.StateOpen:
+		c.logger.Warn("依赖 %s 已熔断 (%s -> %s)", name, from, to)
+		go c.escalate()
+	case resilience.StateClosed:
+		c.logger.Info("依赖 %s 已恢复 (%s -> %s)", name, from, to)
+		go c.recoverFromBreaker()
+	}
+}
+
+// escalate 依赖熔断时禁用节点，避免中心服务器继续分配无法正常提供的请求
+func (c *Cluster) escalate() {
+	c.escalation.mu.Lock()
+	defer c.escalation.mu.Unlock()
+
+	if !c.IsEnabled() {
+		return
+	}
+
+	c.logger.Warn("依赖不可用，暂时禁用节点")
+	if err := c.Disable(c.ctx); err != nil {
+		c.logger.Error("禁用节点失败: %v", err)
+		return
+	}
+	c.escalation.disabled = true
+}
+
+// recoverFromBreaker 所有依赖恢复后重新启用因熔断而禁用的节点
+func (c *Cluster) recoverFromBreaker() {
+	c.escalation.mu.Lock()
+	defer c.escalation.mu.Unlock()
+
+	if !c.escalation.disabled || !c.policy.Healthy() {
+		return
+	}
+	// 运维人员手动禁用的节点保持禁用
+	if c.ManuallyDisabled() {
+		c.logger.Info("所有依赖已恢复，节点已被手动禁用，不重新启用")
+		c.escalation.disabled = false
+		return
+	}
+
+	c.logger.Info("所有依赖已恢复，重新启用节点")
+	if err := c.Enable(c.ctx); err != nil {
+		c.logger.Error("重新启用节点失败: %v", err)
+		return
+	}
+	c.escalation.disabled = false
+}
+
+// deferEnableToBreaker 依赖仍在熔断时由熔断恢复负责重新启用节点
+func (c *Cluster) deferEnableToBreaker() {
+	c.escalation.mu.Lock()
+	defer c.escalation.mu.Unlock()
+	c.escalation.disabled = true
+}
+
+// Healthy 返回所有依赖的熔断器是否都处于关闭状态
+func (c *Cluster) Healthy() bool {
+	return c.policy.Healthy()
+}
+
+// TokenState 返回认证令牌的状态
+func (c *Cluster) TokenState() token.State {
+	return c.tokenMgr.State()
+}
+
+// BreakerStatus 返回各依赖熔断器的状态
+func (c *Cluster) BreakerStatus() []resilience.BreakerStatus {
+	return c.policy.Status()
+}
@@ -0,0 +1,230 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/storage"
+)
+
+// ErrSyncInProgress 已有同步正在运行
+var ErrSyncInProgress = errors.New("已有同步正在进行")
+
+// Scheduler 定期执行增量同步，也可以按需触发，同一时间最多只有一个同步在运行
+type Scheduler struct {
+	cluster   *Cluster
+	logger    *logger.Logger
+	interval  time.Duration
+	threshold int
+
+	running        atomic.Bool
+	started        atomic.Bool
+	stopped        atomic.Bool
+	disabledBySync atomic.Bool
+	lastRun        atomic.Int64
+	lastGC         atomic.Int64
+	// fullListed 本进程内是否已获取过完整文件列表
+	fullListed atomic.Bool
+
+	trigger chan struct{}
+	done    chan struct{}
+
+	// ctx 在Stop时取消，正在运行的同步随之中止
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewScheduler 创建同步调度器，缺失文件数超过threshold时会在同步期间禁用节点
+func NewScheduler(cluster *Cluster, interval time.Duration, threshold int, logger *logger.Logger) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		cluster:   cluster,
+		logger:    logger,
+		interval:  interval,
+		threshold: threshold,
+		trigger:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start 在后台启动定期同步，重复调用无效
+func (s *Scheduler) Start() {
+	if !s.started.CompareAndSwap(false, true) {
+		return
+	}
+	go s.loop()
+}
+
+// Stop 停止定期同步，取消正在运行的同步并等待其结束
+func (s *Scheduler) Stop() {
+	if !s.stopped.CompareAndSwap(false, true) {
+		return
+	}
+	s.cancel()
+	if s.started.Load() {
+		<-s.done
+	}
+}
+
+// Trigger 请求尽快执行一次同步，不会阻塞；已有待执行的请求时返回false
+func (s *Scheduler) Trigger() bool {
+	select {
+	case s.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Running 返回当前是否有同步正在运行
+func (s *Scheduler) Running() bool {
+	return s.running.Load()
+}
+
+// LastRun 返回上一次同步完成的时间
+func (s *Scheduler) LastRun() time.Time {
+	last := s.lastRun.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// loop 调度循环
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+
+		if err := s.RunOnce(s.ctx); err != nil && !errors.Is(err, ErrSyncInProgress) && s.ctx.Err() == nil {
+			s.logger.Error("定期同步失败: %v", err)
+		}
+	}
+}
+
+// withStop 返回在ctx或调度器停止时取消的上下文
+func (s *Scheduler) withStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// RunOnce 立即执行一次同步，已有同步在运行时返回ErrSyncInProgress。
+// ctx取消或调度器停止时同步中止
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return ErrSyncInProgress
+	}
+	defer func() {
+		s.lastRun.Store(time.Now().UnixNano())
+		s.running.Store(false)
+	}()
+
+	ctx, cancel := s.withStop(ctx)
+	defer cancel()
+
+	// 到达垃圾回收间隔时获取完整文件列表，以便在同步后清理已移除的文件。
+	// 重定向下载按文件列表中的大小统计流量，进程启动后的首次同步也获取完整列表，
+	// 使水位之前已缓存的文件同样有大小记录
+	gcConfig := s.cluster.Config.GC
+	gcInterval := time.Duration(gcConfig.IntervalHours) * time.Hour
+	full := !s.fullListed.Load() || (gcConfig.Enabled && time.Since(s.LastGC()) >= gcInterval)
+
+	plan, err := s.cluster.syncMgr.Plan(ctx, full)
+	if err != nil {
+		return err
+	}
+	if plan.Full {
+		s.fullListed.Store(true)
+	}
+
+	// 缺失文件过多时先禁用节点，避免中心服务器分配无法提供的文件
+	missing := len(plan.Missing)
+	if missing > s.threshold && s.cluster.IsEnabled() {
+		s.logger.Warn("缺失 %d 个文件，超过阈值 %d，同步期间禁用节点", missing, s.threshold)
+		if err := s.cluster.Disable(ctx); err != nil {
+			s.logger.Error("禁用节点失败: %v", err)
+		} else {
+			s.disabledBySync.Store(true)
+		}
+	}
+
+	result, syncErr := s.cluster.syncMgr.Apply(ctx, plan)
+
+	// 剩余缺失文件回到阈值以内时重新启用由同步禁用的节点，
+	// 依赖仍在熔断时交由熔断恢复后再启用；同步因关闭而中止或节点已被手动禁用时不再启用
+	if s.disabledBySync.Load() && result.Failed <= s.threshold && ctx.Err() == nil {
+		if s.cluster.ManuallyDisabled() {
+			s.logger.Info("节点已被手动禁用，同步完成后不重新启用")
+			s.disabledBySync.Store(false)
+		} else if !s.cluster.Healthy() {
+			s.logger.Warn("依赖仍处于熔断状态，等待恢复后再启用节点")
+			s.cluster.deferEnableToBreaker()
+			s.disabledBySync.Store(false)
+		} else if err := s.cluster.Enable(ctx); err != nil {
+			s.logger.Error("重新启用节点失败: %v", err)
+		} else {
+			s.disabledBySync.Store(false)
+		}
+	}
+
+	// 文件列表完整时执行垃圾回收，失败不影响同步结果
+	if plan.Full && gcConfig.Enabled && ctx.Err() == nil {
+		if _, err := s.cluster.collectGarbage(ctx, plan, gcConfig.DryRun); err != nil {
+			s.logger.Error("垃圾回收失败: %v", err)
+		}
+		s.lastGC.Store(time.Now().UnixNano())
+	}
+
+	return syncErr
+}
+
+// RunGC 立即获取完整文件列表并执行一次垃圾回收，不下载缺失的文件。
+// 已有同步在运行时返回ErrSyncInProgress
+func (s *Scheduler) RunGC(ctx context.Context, dryRun bool) (*storage.GCResult, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, ErrSyncInProgress
+	}
+	defer s.running.Store(false)
+
+	ctx, cancel := s.withStop(ctx)
+	defer cancel()
+
+	plan, err := s.cluster.syncMgr.Plan(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.cluster.collectGarbage(ctx, plan, dryRun)
+	if err != nil {
+		return result, err
+	}
+	s.lastGC.Store(time.Now().UnixNano())
+	return result, nil
+}
+
+// LastGC 返回上一次垃圾回收的时间
+func (s *Scheduler) LastGC() time.Time {
+	last := s.lastGC.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// enableEvents 返回中心服务器收到的enable和disable事件
+func enableEvents(events []string) []string {
+	return slices.DeleteFunc(events, func(event string) bool {
+		return event != "enable" && event != "disable"
+	})
+}
+
+func TestRunOnceInProgress(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	center.AddFile([]byte("file"), 1000)
+	center.SetDownloadDelay(200 * time.Millisecond)
+	s := c.Scheduler()
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() { done <- s.RunOnce(ctx) }()
+	waitFor(t, "同步开始", s.Running)
+
+	if err := s.RunOnce(ctx); !errors.Is(err, ErrSyncInProgress) {
+		t.Errorf("同步期间 RunOnce = %v, want %v", err, ErrSyncInProgress)
+	}
+	if _, err := s.RunGC(ctx, true); !errors.Is(err, ErrSyncInProgress) {
+		t.Errorf("同步期间 RunGC = %v, want %v", err, ErrSyncInProgress)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if s.Running() || s.LastRun().IsZero() {
+		t.Errorf("同步结束后 Running = %v, LastRun = %v", s.Running(), s.LastRun())
+	}
+	if downloads := center.Downloads(); len(downloads) != 1 {
+		t.Errorf("下载记录 = %v, want 1个文件", downloads)
+	}
+
+	// 同步结束后可以再次运行
+	if err := s.RunOnce(ctx); err != nil {
+		t.Errorf("RunOnce: %v", err)
+	}
+}
+
+func TestTriggerPending(t *testing.T) {
+	c, _ := newConnectedCluster(t)
+	s := c.Scheduler()
+
+	// 调度循环未启动，第一次请求一直处于待执行状态
+	if !s.Trigger() {
+		t.Fatal("Trigger = false, want true")
+	}
+	if s.Trigger() {
+		t.Error("已有待执行的请求时 Trigger = true, want false")
+	}
+}
+
+func TestSyncDisablesAboveThreshold(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+	center.AddFile([]byte("file a"), 1000)
+	center.AddFile([]byte("file b"), 2000)
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// 缺失文件数超过阈值时同步期间禁用节点，全部下载完成后重新启用
+	s := c.Scheduler()
+	s.threshold = 1
+	if err := s.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	if got := enableEvents(center.Events()); !slices.Equal(got, []string{"enable", "disable", "enable"}) {
+		t.Errorf("事件 = %v, want [enable disable enable]", got)
+	}
+	if !c.IsEnabled() || !center.Enabled() || s.disabledBySync.Load() {
+		t.Errorf("同步后 IsEnabled = %v, 中心服务器 = %v, disabledBySync = %v",
+			c.IsEnabled(), center.Enabled(), s.disabledBySync.Load())
+	}
+}
+
+func TestSyncBelowThresholdKeepsEnabled(t *testing.T) {
+	c, center := newConnectedCluster(t)
+	ctx := context.Background()
+	center.AddFile([]byte("file a"), 1000)
+	center.AddFile([]byte("file b"), 2000)
+
+	if err := c.Enable(ctx); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// 缺失文件数等于阈值时不禁用节点
+	c.Scheduler().threshold = 2
+	if err := c.Scheduler().RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	if got := enableEvents(center.Events()); !slices.Equal(got, []string{"enable"}) {
+		t.Errorf("事件 = %v, want [enable]", got)
+	}
+	if !c.IsEnabled() {
+		t.Error("同步后节点未启用")
+	}
+}
@@ -0,0 +1,447 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Engine.IO v4 数据包类型
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+)
+
+// Socket.IO v5 数据包类型
+const (
+	socketConnect      = '0'
+	socketDisconnect   = '1'
+	socketEvent        = '2'
+	socketAck          = '3'
+	socketConnectError = '4'
+)
+
+// errSocketClosed 连接已关闭时返回的错误
+var errSocketClosed = errors.New("socket.io连接已关闭")
+
+// errConnectRejected 中心服务器在握手时拒绝了连接
+var errConnectRejected = errors.New("中心服务器拒绝连接")
+
+// engineOpenPacket Engine.IO握手数据
+type engineOpenPacket struct {
+	SID          string `json:"sid"`
+	PingInterval int    `json:"pingInterval"`
+	PingTimeout  int    `json:"pingTimeout"`
+}
+
+// socketClient 一个仅支持websocket传输和默认命名空间的最小Socket.IO客户端
+type socketClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	nextAck  int
+	acks     map[int]chan []json.RawMessage
+	handlers map[string]func(args []json.RawMessage)
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closedBy  string
+}
+
+// socketURL 将中心服务器地址转换为Socket.IO的websocket地址
+func socketURL(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("无法解析服务器地址 %s: %w", serverURL, err)
+	}
+
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "wss"
+	case "http", "ws":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("不支持的服务器地址协议: %s", u.Scheme)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/socket.io/"
+	u.RawQuery = url.Values{
+		"EIO":       {"4"},
+		"transport": {"websocket"},
+	}.Encode()
+
+	return u.String(), nil
+}
+
+// dialSocket 建立Socket.IO连接并完成命名空间握手
+func dialSocket(ctx context.Context, serverURL string, auth interface{}, header http.Header) (*socketClient, error) {
+	wsURL, err := socketURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("无法建立websocket连接: %w", err)
+	}
+
+	s := &socketClient{
+		conn:     conn,
+		acks:     make(map[int]chan []json.RawMessage),
+		handlers: make(map[string]func(args []json.RawMessage)),
+		done:     make(chan struct{}),
+	}
+
+	// 握手阶段的超时由ctx决定
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	if err := s.handshake(auth); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go s.readLoop()
+
+	return s, nil
+}
+
+// handshake 读取Engine.IO握手包并连接默认命名空间
+func (s *socketClient) handshake(auth interface{}) error {
+	_, msg, err := s.conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("无法读取握手数据: %w", err)
+	}
+	if len(msg) == 0 || msg[0] != engineOpen {
+		return fmt.Errorf("无效的握手数据: %s", string(msg))
+	}
+
+	var open engineOpenPacket
+	if err := json.Unmarshal(msg[1:], &open); err != nil {
+		return fmt.Errorf("无法解析握手数据: %w", err)
+	}
+	s.pingInterval = time.Duration(open.PingInterval) * time.Millisecond
+	s.pingTimeout = time.Duration(open.PingTimeout) * time.Millisecond
+
+	// 连接默认命名空间，附带认证信息
+	packet := string([]byte{engineMessage, socketConnect})
+	if auth != nil {
+		data, err := json.Marshal(auth)
+		if err != nil {
+			return fmt.Errorf("无法序列化认证信息: %w", err)
+		}
+		packet += string(data)
+	}
+	if err := s.write(packet); err != nil {
+		return fmt.Errorf("无法发送连接请求: %w", err)
+	}
+
+	for {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("无法读取连接响应: %w", err)
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch msg[0] {
+		case enginePing:
+			if err := s.write(string(enginePong)); err != nil {
+				return err
+			}
+		case engineMessage:
+			if len(msg) < 2 {
+				continue
+			}
+			switch msg[1] {
+			case socketConnect:
+				return nil
+			case socketConnectError:
+				return fmt.Errorf("%w: %s", errConnectRejected, parseSocketError(msg[2:]))
+			}
+		case engineClose:
+			return fmt.Errorf("中心服务器在握手期间关闭了连接")
+		}
+	}
+}
+
+// On 注册服务器事件处理函数，需在连接建立后立即调用
+func (s *socketClient) On(event string, handler func(args []json.RawMessage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[event] = handler
+}
+
+// Emit 发送不需要确认的事件
+func (s *socketClient) Emit(event string, args ...interface{}) error {
+	payload, err := encodeEvent(event, args)
+	if err != nil {
+		return err
+	}
+	return s.write(string([]byte{engineMessage, socketEvent}) + payload)
+}
+
+// EmitWithAck 发送事件并等待服务器确认
+func (s *socketClient) EmitWithAck(ctx context.Context, event string, args ...interface{}) ([]json.RawMessage, error) {
+	payload, err := encodeEvent(event, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []json.RawMessage, 1)
+	s.mu.Lock()
+	id := s.nextAck
+	s.nextAck++
+	s.acks[id] = ch
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.acks, id)
+		s.mu.Unlock()
+	}()
+
+	packet := string([]byte{engineMessage, socketEvent}) + strconv.Itoa(id) + payload
+	if err := s.write(packet); err != nil {
+		return nil, err
+	}
+
+	select {
+	case data := <-ch:
+		return data, nil
+	case <-s.done:
+		return nil, errSocketClosed
+	case <-ctx.Done():
+		return nil, fmt.Errorf("等待事件 %s 的确认超时: %w", event, ctx.Err())
+	}
+}
+
+// Close 主动断开连接
+func (s *socketClient) Close() error {
+	// 尽力通知服务器断开命名空间，忽略错误
+	_ = s.write(string([]byte{engineMessage, socketDisconnect}))
+	s.shutdown("client close")
+	return nil
+}
+
+// Done 返回连接关闭时被关闭的通道
+func (s *socketClient) Done() <-chan struct{} {
+	return s.done
+}
+
+// write 并发安全地写入一帧文本消息
+func (s *socketClient) write(packet string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	select {
+	case <-s.done:
+		return errSocketClosed
+	default:
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return s.conn.WriteMessage(websocket.TextMessage, []byte(packet))
+}
+
+// Reason 返回连接关闭的原因
+func (s *socketClient) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closedBy
+}
+
+// shutdown 关闭底层连接，只会执行一次
+func (s *socketClient) shutdown(reason string) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closedBy = reason
+		s.mu.Unlock()
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// readLoop 持续读取服务器消息，直到连接关闭
+func (s *socketClient) readLoop() {
+	for {
+		if s.pingInterval > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.pingInterval + s.pingTimeout))
+		} else {
+			s.conn.SetReadDeadline(time.Time{})
+		}
+
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			s.shutdown(fmt.Sprintf("transport error: %v", err))
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch msg[0] {
+		case enginePing:
+			if err := s.write(string(enginePong)); err != nil {
+				s.shutdown(fmt.Sprintf("transport error: %v", err))
+				return
+			}
+		case engineClose:
+			s.shutdown("transport close")
+			return
+		case engineMessage:
+			if len(msg) < 2 {
+				continue
+			}
+			if s.handlePacket(msg[1], msg[2:]) {
+				return
+			}
+		}
+	}
+}
+
+// handlePacket 处理Socket.IO数据包，返回true表示连接已结束
+func (s *socketClient) handlePacket(typ byte, data []byte) bool {
+	switch typ {
+	case socketDisconnect:
+		s.shutdown("io server disconnect")
+		return true
+	case socketConnectError:
+		s.shutdown(fmt.Sprintf("connect error: %s", parseSocketError(data)))
+		return true
+	case socketEvent:
+		id, payload := splitAckID(data)
+		var args []json.RawMessage
+		if err := json.Unmarshal(payload, &args); err != nil || len(args) == 0 {
+			return false
+		}
+		var event string
+		if err := json.Unmarshal(args[0], &event); err != nil {
+			return false
+		}
+
+		s.mu.Lock()
+		handler := s.handlers[event]
+		s.mu.Unlock()
+		if handler != nil {
+			handler(args[1:])
+		}
+
+		// 服务器请求确认时回复空确认
+		if id >= 0 {
+			_ = s.write(string([]byte{engineMessage, socketAck}) + strconv.Itoa(id) + "[]")
+		}
+	case socketAck:
+		id, payload := splitAckID(data)
+		if id < 0 {
+			return false
+		}
+		var args []json.RawMessage
+		if err := json.Unmarshal(payload, &args); err != nil {
+			return false
+		}
+
+		s.mu.Lock()
+		ch := s.acks[id]
+		s.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- args:
+			default:
+			}
+		}
+	}
+	return false
+}
+
+// encodeEvent 将事件名和参数编码为JSON数组
+func encodeEvent(event string, args []interface{}) (string, error) {
+	items := make([]interface{}, 0, len(args)+1)
+	items = append(items, event)
+	items = append(items, args...)
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return "", fmt.Errorf("无法序列化事件 %s: %w", event, err)
+	}
+	return string(data), nil
+}
+
+// splitAckID 拆分数据包开头的确认ID，没有ID时返回-1
+func splitAckID(data []byte) (int, []byte) {
+	i := 0
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return -1, data
+	}
+	id, err := strconv.Atoi(string(data[:i]))
+	if err != nil {
+		return -1, data[i:]
+	}
+	return id, data[i:]
+}
+
+// parseSocketError 解析服务器返回的错误对象
+func parseSocketError(data []byte) string {
+	if len(data) == 0 || string(data) == "null" {
+		return ""
+	}
+
+	var obj struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &obj); err == nil && obj.Message != "" {
+		return obj.Message
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		return str
+	}
+
+	return string(data)
+}
+
+// parseAck 解析中心服务器约定的 [err, data] 确认格式
+func parseAck(args []json.RawMessage) (json.RawMessage, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("确认数据为空")
+	}
+
+	var pair []json.RawMessage
+	if err := json.Unmarshal(args[0], &pair); err != nil {
+		// 兼容直接以多个参数返回的形式
+		pair = args
+	}
+	if len(pair) == 0 {
+		return nil, fmt.Errorf("确认数据为空")
+	}
+
+	if msg := parseSocketError(pair[0]); msg != "" {
+		return nil, errors.New(msg)
+	}
+	if len(pair) < 2 {
+		return nil, nil
+	}
+	return pair[1], nil
+}
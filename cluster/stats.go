@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"sync/atomic"
+)
+
+// StatsSnapshot 某一时间窗口内的请求数与流量
+type StatsSnapshot struct {
+	Hits  int64 `json:"hits"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Stats 统计节点提供服务的请求数与流量，所有方法均可并发调用
+type Stats struct {
+	// 当前保活周期内尚未上报的计数
+	hits  atomic.Int64
+	bytes atomic.Int64
+
+	// 进程启动以来的累计计数
+	totalHits  atomic.Int64
+	totalBytes atomic.Int64
+
+	// 垃圾回收累计删除的文件数与字节数
+	gcFiles atomic.Int64
+	gcBytes atomic.Int64
+}
+
+// NewStats 创建新的统计实例
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// RecordHit 记录一次请求及其传输的字节数，重定向请求按文件大小计入
+func (s *Stats) RecordHit(bytes int64) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	s.hits.Add(1)
+	s.bytes.Add(bytes)
+	s.totalHits.Add(1)
+	s.totalBytes.Add(bytes)
+}
+
+// Snapshot 取出当前窗口的计数并清零，用于一次保活上报
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Hits:  s.hits.Swap(0),
+		Bytes: s.bytes.Swap(0),
+	}
+}
+
+// Rollback 上报失败时将计数归还到下一个窗口，避免流量丢失
+func (s *Stats) Rollback(snap StatsSnapshot) {
+	s.hits.Add(snap.Hits)
+	s.bytes.Add(snap.Bytes)
+}
+
+// Pending 返回当前窗口尚未上报的计数，不会清零
+func (s *Stats) Pending() StatsSnapshot {
+	return StatsSnapshot{
+		Hits:  s.hits.Load(),
+		Bytes: s.bytes.Load(),
+	}
+}
+
+// Total 返回进程启动以来的累计计数
+func (s *Stats) Total() StatsSnapshot {
+	return StatsSnapshot{
+		Hits:  s.totalHits.Load(),
+		Bytes: s.totalBytes.Load(),
+	}
+}
+
+// RecordGC 记录一次垃圾回收删除的文件数与字节数
+func (s *Stats) RecordGC(files int, bytes int64) {
+	s.gcFiles.Add(int64(files))
+	s.gcBytes.Add(bytes)
+}
+
+// GCTotal 返回进程启动以来垃圾回收累计删除的文件数与字节数
+func (s *Stats) GCTotal() (int64, int64) {
+	return s.gcFiles.Load(), s.gcBytes.Load()
+}
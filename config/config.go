@@ -1,12 +1,21 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/pelletier/go-toml/v2" // 用于 TOML 格式支持
+
+	"github.com/uright008/go-openbmclapi-reborn/utils"
 )
 
+// ErrDefaultConfigCreated 配置文件不存在，已创建默认配置文件，需要修改后重新启动
+var ErrDefaultConfigCreated = errors.New("已创建默认配置文件，请修改配置后重新启动程序")
+
+// DefaultServerURL 官方中心服务器地址
+const DefaultServerURL = "https://openbmclapi.bangbang93.com"
+
 // ClusterConfig 集群配置
 type ClusterConfig struct {
 	ID         string `toml:"id"`
@@ -16,6 +25,8 @@ type ClusterConfig struct {
 	PublicPort int    `toml:"public_port"`
 	BYOC       bool   `toml:"byoc"`
 	ServerURL  string `toml:"server_url"` // 新增服务器URL配置
+	// IPEchoURL 返回请求方公网IP的HTTP服务，用于自动获取公网地址，设为空时不使用
+	IPEchoURL string `toml:"ip_echo_url"`
 }
 
 // StorageConfig 存储配置
@@ -24,6 +35,7 @@ type StorageConfig struct {
 	Path   string       `toml:"path"`
 	WebDAV WebDAVConfig `toml:"webdav"`
 	AList  AListConfig  `toml:"alist"`
+	S3     S3Config     `toml:"s3"`
 }
 
 // WebDAVConfig WebDAV配置
@@ -43,6 +55,26 @@ type AListConfig struct {
 	Token    string `toml:"token"`
 }
 
+// S3Config S3兼容对象存储配置
+type S3Config struct {
+	Endpoint        string `toml:"endpoint"`
+	Region          string `toml:"region"`
+	Bucket          string `toml:"bucket"`
+	AccessKeyID     string `toml:"access_key_id"`
+	SecretAccessKey string `toml:"secret_access_key"`
+	SessionToken    string `toml:"session_token"`
+	// Prefix 对象键的前缀，为空时文件直接存放在存储桶根目录
+	Prefix string `toml:"prefix"`
+	// PathStyle 使用 endpoint/bucket/key 形式的地址，MinIO等自建服务通常需要开启
+	PathStyle bool `toml:"path_style"`
+	// PresignExpirySeconds 下载重定向使用的预签名URL有效期(秒)
+	PresignExpirySeconds int `toml:"presign_expiry_seconds"`
+	// MultipartThresholdMB 超过该大小(MB)的文件使用分片上传
+	MultipartThresholdMB int `toml:"multipart_threshold_mb"`
+	// PartSizeMB 分片上传时每个分片的大小(MB)，不小于5
+	PartSizeMB int `toml:"part_size_mb"`
+}
+
 // SecurityConfig 安全配置
 type SecurityConfig struct {
 	SSLKey  string `toml:"ssl_key"`
@@ -64,31 +96,82 @@ type DebugConfig struct {
 // SystemConfig 系统配置
 type SystemConfig struct {
 	Timezone string `toml:"timezone"`
+	DataDir  string `toml:"data_dir"` // 保存证书等运行数据的目录
 }
 
 // LogConfig 日志配置
 type LogConfig struct {
-	Level    string `toml:"level"`
-	Format   string `toml:"format"`
-	Encoding string `toml:"encoding"` // 新增编码配置
+	Level          string `toml:"level"`
+	Format         string `toml:"format"`          // text 或 json
+	Encoding       string `toml:"encoding"`        // 新增编码配置
+	File           string `toml:"file"`            // 日志文件路径，留空时不写文件
+	MaxSizeMB      int    `toml:"max_size_mb"`     // 日志文件超过该大小(MB)时轮转
+	MaxBackups     int    `toml:"max_backups"`     // 保留的轮转文件数量，0表示不限制
+	MaxAgeDays     int    `toml:"max_age_days"`    // 轮转文件的保留天数，0表示不限制
+	DisableConsole bool   `toml:"disable_console"` // 不输出到标准输出
 }
 
 // SyncConfig 同步配置
 type SyncConfig struct {
-	MaxConcurrency  int `toml:"max_concurrency"`
-	StartIntervalMs int `toml:"start_interval_ms"`
+	MaxConcurrency   int `toml:"max_concurrency"`
+	StartIntervalMs  int `toml:"start_interval_ms"`
+	IntervalMinutes  int `toml:"interval_minutes"`  // 定期同步间隔（分钟）
+	DisableThreshold int `toml:"disable_threshold"` // 缺失文件数超过该值时在同步期间禁用节点
+}
+
+// GCConfig 垃圾回收配置
+type GCConfig struct {
+	Enabled        bool    `toml:"enabled"`
+	DryRun         bool    `toml:"dry_run"`          // 只统计不删除
+	MaxDeleteRatio float64 `toml:"max_delete_ratio"` // 单次最多删除的缓存比例，超过时拒绝执行
+	IntervalHours  int     `toml:"interval_hours"`   // 两次垃圾回收之间的最短间隔（小时）
+}
+
+// MetricsConfig 指标配置
+type MetricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"` // 单独的监听地址，如 "127.0.0.1:9100"，留空时在管理接口的 /metrics 提供并需要认证
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"` // 管理接口的监听地址，建议只监听内网或本机
+	Token   string `toml:"token"`  // 访问管理接口所需的Bearer令牌
+}
+
+// AccessLogConfig 访问日志配置，features.disable_access_log 为true时不记录
+type AccessLogConfig struct {
+	File           string   `toml:"file"`            // 访问日志文件路径
+	Format         string   `toml:"format"`          // combined 或 json
+	TrustedProxies []string `toml:"trusted_proxies"` // 信任其X-Forwarded-For头的代理地址或网段
+	MaxSizeMB      int      `toml:"max_size_mb"`     // 日志文件超过该大小(MB)时轮转
+	MaxBackups     int      `toml:"max_backups"`     // 保留的轮转文件数量，0表示不限制
+	MaxAgeDays     int      `toml:"max_age_days"`    // 轮转文件的保留天数，0表示不限制
+}
+
+// NginxConfig nginx模式配置，features.enable_nginx 为true时由nginx对外提供文件
+type NginxConfig struct {
+	Listen     string `toml:"listen"`      // 本程序监听的内部地址，供nginx的auth_request和反向代理使用
+	ConfigPath string `toml:"config_path"` // 生成的nginx配置文件路径，需在nginx的http块中include
+	AccessLog  string `toml:"access_log"`  // nginx访问日志路径，用于统计命中次数和流量
 }
 
 // Config 主配置结构
 type Config struct {
-	Cluster  ClusterConfig  `toml:"cluster"`
-	Storage  StorageConfig  `toml:"storage"`
-	Security SecurityConfig `toml:"security"`
-	Features FeaturesConfig `toml:"features"`
-	Debug    DebugConfig    `toml:"debug"`
-	System   SystemConfig   `toml:"system"`
-	Log      LogConfig      `toml:"log"`
-	Sync     SyncConfig     `toml:"sync"`
+	Cluster   ClusterConfig   `toml:"cluster"`
+	Storage   StorageConfig   `toml:"storage"`
+	Security  SecurityConfig  `toml:"security"`
+	Features  FeaturesConfig  `toml:"features"`
+	Debug     DebugConfig     `toml:"debug"`
+	System    SystemConfig    `toml:"system"`
+	Log       LogConfig       `toml:"log"`
+	Sync      SyncConfig      `toml:"sync"`
+	GC        GCConfig        `toml:"gc"`
+	Metrics   MetricsConfig   `toml:"metrics"`
+	Admin     AdminConfig     `toml:"admin"`
+	AccessLog AccessLogConfig `toml:"access_log"`
+	Nginx     NginxConfig     `toml:"nginx"`
 }
 
 // Load 从文件加载配置，如果文件不存在则创建默认配置
@@ -96,13 +179,11 @@ func Load(filename string) (*Config, error) {
 	// 检查配置文件是否存在
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		// 配置文件不存在，创建默认配置文件
-		fmt.Printf("配置文件 %s 不存在，正在创建默认配置文件...\n", filename)
 		err := createDefaultConfig(filename)
 		if err != nil {
-			return nil, fmt.Errorf("无法创建默认配置文件: %w", err)
+			return nil, fmt.Errorf("配置文件 %s 不存在，且无法创建默认配置文件: %w", filename, err)
 		}
-		fmt.Printf("已创建默认配置文件 %s，请修改配置后重新启动程序\n", filename)
-		return nil, fmt.Errorf("请修改配置文件后重新启动程序")
+		return nil, fmt.Errorf("%w: %s", ErrDefaultConfigCreated, filename)
 	}
 
 	// 读取配置文件
@@ -111,7 +192,10 @@ func Load(filename string) (*Config, error) {
 		return nil, fmt.Errorf("无法读取配置文件: %w", err)
 	}
 
-	var config Config
+	// 配置文件中未出现的字段保留这里的默认值，显式设为空时才会被覆盖
+	config := Config{
+		Cluster: ClusterConfig{IPEchoURL: utils.DefaultIPEchoURL},
+	}
 	err = toml.Unmarshal(data, &config)
 	if err != nil {
 		return nil, fmt.Errorf("无法解析配置文件: %w", err)
@@ -133,7 +217,8 @@ func createDefaultConfig(filename string) error {
 			Port:       4000,
 			PublicPort: 0,
 			BYOC:       false,
-			ServerURL:  "https://openbmclapi.bangbang93.com", // 添加默认服务器URL
+			ServerURL:  DefaultServerURL, // 添加默认服务器URL
+			IPEchoURL:  utils.DefaultIPEchoURL,
 		},
 		Storage: StorageConfig{
 			Type: "file",
@@ -152,6 +237,16 @@ func createDefaultConfig(filename string) error {
 				Password: "admin",                 // AList密码
 				Path:     "/data",                 // AList存储路径
 			},
+			S3: S3Config{
+				// 示例配置，根据实际情况修改
+				Endpoint:             "http://127.0.0.1:9000", // S3服务地址
+				Region:               "us-east-1",             // 区域
+				Bucket:               "openbmclapi",           // 存储桶
+				PathStyle:            true,                    // MinIO使用路径形式的地址
+				PresignExpirySeconds: 600,                     // 预签名URL有效期
+				MultipartThresholdMB: 64,                      // 分片上传阈值
+				PartSizeMB:           16,                      // 分片大小
+			},
 		},
 		Security: SecurityConfig{
 			SSLKey:  "",
@@ -167,15 +262,50 @@ func createDefaultConfig(filename string) error {
 		},
 		System: SystemConfig{
 			Timezone: "Asia/Shanghai",
+			DataDir:  "./data",
 		},
 		Log: LogConfig{
-			Level:    "info",
-			Format:   "text",
-			Encoding: "utf-8", // 添加默认编码
+			Level:      "info",
+			Format:     "text",
+			Encoding:   "utf-8", // 添加默认编码
+			File:       "",
+			MaxSizeMB:  100,
+			MaxBackups: 7,
+			MaxAgeDays: 30,
 		},
 		Sync: SyncConfig{
-			MaxConcurrency:  64,
-			StartIntervalMs: 100,
+			MaxConcurrency:   64,
+			StartIntervalMs:  100,
+			IntervalMinutes:  10,
+			DisableThreshold: 100,
+		},
+		GC: GCConfig{
+			Enabled:        true,
+			DryRun:         false,
+			MaxDeleteRatio: 0.5,
+			IntervalHours:  24,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Listen:  "",
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Listen:  "127.0.0.1:4001",
+			Token:   "",
+		},
+		AccessLog: AccessLogConfig{
+			File:           "logs/access.log",
+			Format:         "combined",
+			TrustedProxies: []string{"127.0.0.1", "::1"},
+			MaxSizeMB:      100,
+			MaxBackups:     7,
+			MaxAgeDays:     30,
+		},
+		Nginx: NginxConfig{
+			Listen:     "127.0.0.1:4010",
+			ConfigPath: "nginx/openbmclapi.conf",
+			AccessLog:  "logs/nginx-access.log",
 		},
 	}
 
@@ -201,7 +331,7 @@ func setDefaults(config *Config) {
 	}
 
 	if config.Cluster.ServerURL == "" {
-		config.Cluster.ServerURL = "https://openbmclapi.bangbang93.com"
+		config.Cluster.ServerURL = DefaultServerURL
 	}
 
 	if config.Storage.Path == "" {
@@ -228,10 +358,37 @@ func setDefaults(config *Config) {
 		config.Storage.AList.Path = "/data"
 	}
 
+	if config.Storage.S3.Region == "" {
+		config.Storage.S3.Region = "us-east-1"
+	}
+
+	// S3预签名URL的有效期最长为7天
+	if config.Storage.S3.PresignExpirySeconds <= 0 {
+		config.Storage.S3.PresignExpirySeconds = 600
+	}
+	if config.Storage.S3.PresignExpirySeconds > 7*24*3600 {
+		config.Storage.S3.PresignExpirySeconds = 7 * 24 * 3600
+	}
+
+	if config.Storage.S3.MultipartThresholdMB <= 0 {
+		config.Storage.S3.MultipartThresholdMB = 64
+	}
+
+	// S3要求除最后一个分片外每个分片不小于5MB
+	if config.Storage.S3.PartSizeMB <= 0 {
+		config.Storage.S3.PartSizeMB = 16
+	} else if config.Storage.S3.PartSizeMB < 5 {
+		config.Storage.S3.PartSizeMB = 5
+	}
+
 	if config.System.Timezone == "" {
 		config.System.Timezone = "Asia/Shanghai"
 	}
 
+	if config.System.DataDir == "" {
+		config.System.DataDir = "./data"
+	}
+
 	if config.Log.Level == "" {
 		config.Log.Level = "info"
 	}
@@ -245,6 +402,10 @@ func setDefaults(config *Config) {
 		config.Log.Encoding = "utf-8"
 	}
 
+	if config.Log.MaxSizeMB <= 0 {
+		config.Log.MaxSizeMB = 100
+	}
+
 	// 设置同步配置默认值
 	if config.Sync.MaxConcurrency <= 0 {
 		config.Sync.MaxConcurrency = 64
@@ -253,4 +414,72 @@ func setDefaults(config *Config) {
 	if config.Sync.StartIntervalMs <= 0 {
 		config.Sync.StartIntervalMs = 100
 	}
+
+	if config.Sync.IntervalMinutes <= 0 {
+		config.Sync.IntervalMinutes = 10
+	}
+
+	if config.Sync.DisableThreshold <= 0 {
+		config.Sync.DisableThreshold = 100
+	}
+
+	// 设置垃圾回收配置默认值
+	if config.GC.MaxDeleteRatio <= 0 {
+		config.GC.MaxDeleteRatio = 0.5
+	}
+
+	if config.GC.IntervalHours <= 0 {
+		config.GC.IntervalHours = 24
+	}
+
+	// 设置管理接口默认值
+	if config.Admin.Listen == "" {
+		config.Admin.Listen = "127.0.0.1:4001"
+	}
+
+	// 设置访问日志默认值
+	if config.AccessLog.File == "" {
+		config.AccessLog.File = "logs/access.log"
+	}
+	if config.AccessLog.Format == "" {
+		config.AccessLog.Format = "combined"
+	}
+	if config.AccessLog.MaxSizeMB <= 0 {
+		config.AccessLog.MaxSizeMB = 100
+	}
+
+	// 设置nginx模式默认值
+	if config.Nginx.Listen == "" {
+		config.Nginx.Listen = "127.0.0.1:4010"
+	}
+	if config.Nginx.ConfigPath == "" {
+		config.Nginx.ConfigPath = "nginx/openbmclapi.conf"
+	}
+	if config.Nginx.AccessLog == "" {
+		config.Nginx.AccessLog = "logs/nginx-access.log"
+	}
+}
+
+// redacted 替换敏感字段的占位符
+const redacted = "******"
+
+// Redacted 返回隐去密钥、密码等敏感字段的配置副本
+func (c *Config) Redacted() *Config {
+	copied := *c
+
+	for _, field := range []*string{
+		&copied.Cluster.Secret,
+		&copied.Storage.WebDAV.Password,
+		&copied.Storage.AList.Password,
+		&copied.Storage.AList.Token,
+		&copied.Storage.S3.SecretAccessKey,
+		&copied.Storage.S3.SessionToken,
+		&copied.Admin.Token,
+	} {
+		if *field != "" {
+			*field = redacted
+		}
+	}
+
+	return &copied
 }
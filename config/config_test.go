@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uright008/go-openbmclapi-reborn/utils"
+)
+
+func TestLoadIPEchoURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"未配置时使用默认服务", "[cluster]\nid = \"a\"\n", utils.DefaultIPEchoURL},
+		{"自定义服务", "[cluster]\nip_echo_url = \"https://ip.example\"\n", "https://ip.example"},
+		{"设为空时禁用", "[cluster]\nip_echo_url = \"\"\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("写入配置: %v", err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Cluster.IPEchoURL != tt.want {
+				t.Errorf("IPEchoURL = %q, want %q", cfg.Cluster.IPEchoURL, tt.want)
+			}
+		})
+	}
+}
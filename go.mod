@@ -3,17 +3,28 @@ module github.com/uright008/go-openbmclapi-reborn
 go 1.22
 
 require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/huin/goupnp v1.3.0
 	github.com/klauspost/compress v1.18.0
 	github.com/linkedin/goavro/v2 v2.14.0
 	github.com/pelletier/go-toml/v2 v2.0.0
+	github.com/prometheus/client_golang v1.19.1
+	github.com/studio-b12/gowebdav v0.10.0
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/schollz/progressbar/v3 v3.18.0 // indirect
-	github.com/studio-b12/gowebdav v0.10.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
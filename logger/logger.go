@@ -1,65 +1,322 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/config"
 )
 
-// Logger 定义日志记录器结构
+// Level 日志级别
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+// String 返回级别名称
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	default:
+		return strconv.Itoa(int(l))
+	}
+}
+
+// ParseLevel 解析配置中的日志级别
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("未知的日志级别: %s", s)
+	}
+}
+
+// 日志输出格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// sink 同一组日志记录器共享的输出目标和级别
+type sink struct {
+	level   atomic.Int32
+	json    bool
+	mu      sync.Mutex
+	out     io.Writer
+	closers []io.Closer
+
+	// configured 配置的级别，关闭调试模式时恢复到该级别
+	configured atomic.Int32
+}
+
+// field 附加在日志上的键值对
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger 定义日志记录器结构，所有方法均可并发调用
 type Logger struct {
-	debugMode atomic.Bool
-	logger    *log.Logger
+	sink   *sink
+	fields []field
 }
 
-// New 创建新的日志记录器
+// New 创建输出文本格式到标准输出的日志记录器
 func New(debug bool) *Logger {
-	l := &Logger{
-		logger: log.New(os.Stdout, "", log.LstdFlags),
-	}
-	l.debugMode.Store(debug)
+	l := &Logger{sink: &sink{out: os.Stdout}}
+	l.SetLevel(LevelInfo)
+	l.SetDebug(debug)
 	return l
 }
 
-// SetDebug 设置调试模式
+// NewFromConfig 根据日志配置创建日志记录器，支持文本/JSON格式以及按大小轮转的日志文件
+func NewFromConfig(cfg config.LogConfig) (*Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sink{}
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatText:
+	case FormatJSON:
+		s.json = true
+	default:
+		return nil, fmt.Errorf("未知的日志格式: %s", cfg.Format)
+	}
+
+	// 日志始终以UTF-8输出，JSON格式也要求UTF-8
+	switch strings.ToLower(strings.ReplaceAll(cfg.Encoding, "-", "")) {
+	case "", "utf8":
+	default:
+		return nil, fmt.Errorf("不支持的日志编码: %s，目前仅支持 utf-8", cfg.Encoding)
+	}
+
+	var writers []io.Writer
+	if !cfg.DisableConsole {
+		writers = append(writers, os.Stdout)
+	}
+	if cfg.File != "" {
+		file, err := NewRotatingWriter(cfg.File, cfg.MaxSizeMB, cfg.MaxBackups, cfg.MaxAgeDays)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, file)
+		s.closers = append(s.closers, file)
+	}
+
+	switch len(writers) {
+	case 0:
+		s.out = io.Discard
+	case 1:
+		s.out = writers[0]
+	default:
+		s.out = io.MultiWriter(writers...)
+	}
+	l := &Logger{sink: s}
+	l.SetLevel(level)
+	return l, nil
+}
+
+// With 返回附加了键值对的子日志记录器，参数按key1, value1, key2, value2...的顺序传入
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyvals)/2+1)
+	copy(fields, l.fields)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 >= len(keyvals) {
+			fields = append(fields, field{key: "!BADKEY", value: key})
+			break
+		}
+		fields = append(fields, field{key: key, value: keyvals[i+1]})
+	}
+
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// SetDebug 设置调试模式，关闭时恢复到配置的级别，配置的级别为debug时恢复为info
 func (l *Logger) SetDebug(debug bool) {
-	l.debugMode.Store(debug)
+	level := Level(l.sink.configured.Load())
+	if debug {
+		level = LevelDebug
+	} else if level <= LevelDebug {
+		level = LevelInfo
+	}
+	l.sink.level.Store(int32(level))
+}
+
+// IsDebug 返回是否处于调试模式
+func (l *Logger) IsDebug() bool {
+	return l.GetLevel() <= LevelDebug
+}
+
+// SetLevel 设置最低输出级别，并作为关闭调试模式后恢复的级别
+func (l *Logger) SetLevel(level Level) {
+	l.sink.configured.Store(int32(level))
+	l.sink.level.Store(int32(level))
+}
+
+// GetLevel 返回当前的最低输出级别
+func (l *Logger) GetLevel() Level {
+	return Level(l.sink.level.Load())
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	var firstErr error
+	for _, closer := range l.sink.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.sink.closers = nil
+	return firstErr
 }
 
 // Debug 记录调试信息
 func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.debugMode.Load() {
-		l.logger.Printf("[DEBUG] "+format, v...)
-	}
+	l.log(LevelDebug, format, v...)
 }
 
 // Info 记录一般信息
 func (l *Logger) Info(format string, v ...interface{}) {
-	l.logger.Printf("[INFO] "+format, v...)
+	l.log(LevelInfo, format, v...)
 }
 
 // Warn 记录警告信息
 func (l *Logger) Warn(format string, v ...interface{}) {
-	l.logger.Printf("[WARN] "+format, v...)
+	l.log(LevelWarn, format, v...)
 }
 
 // Error 记录错误信息
 func (l *Logger) Error(format string, v ...interface{}) {
-	l.logger.Printf("[ERROR] "+format, v...)
+	l.log(LevelError, format, v...)
 }
 
 // Fatal 记录致命错误并退出程序
 func (l *Logger) Fatal(format string, v ...interface{}) {
-	l.logger.Fatalf("[FATAL] "+format, v...)
-	// 为了确保程序退出，添加显式的os.Exit调用
+	l.log(LevelFatal, format, v...)
+	l.Close()
 	os.Exit(1)
 }
 
 // LogRequest 记录HTTP请求
 func (l *Logger) LogRequest(method, url string, duration time.Duration, statusCode int) {
-	l.logger.Printf("[REQUEST] %s %s %d %v", method, url, statusCode, duration)
+	l.With("method", method, "url", url, "status", statusCode, "duration", duration).Info("HTTP请求")
+}
+
+// log 按级别格式化并写出一条日志
+func (l *Logger) log(level Level, format string, v ...interface{}) {
+	if level < l.GetLevel() {
+		return
+	}
+
+	now := time.Now()
+	msg := format
+	if len(v) > 0 {
+		msg = fmt.Sprintf(format, v...)
+	}
+
+	var line []byte
+	if l.sink.json {
+		line = l.formatJSON(now, level, msg)
+	} else {
+		line = l.formatText(now, level, msg)
+	}
+
+	l.sink.mu.Lock()
+	l.sink.out.Write(line)
+	l.sink.mu.Unlock()
+}
+
+// formatText 以"时间 [LEVEL] 消息 key=value"的格式输出
+func (l *Logger) formatText(now time.Time, level Level, msg string) []byte {
+	var b strings.Builder
+	b.WriteString(now.Format("2006/01/02 15:04:05"))
+	b.WriteString(" [")
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString("] ")
+	b.WriteString(strings.TrimRight(msg, "\n"))
+
+	for _, f := range l.fields {
+		b.WriteByte(' ')
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		value := fmt.Sprint(formatValue(f.value))
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+
+	return []byte(b.String())
+}
+
+// formatJSON 以每行一个JSON对象的格式输出
+func (l *Logger) formatJSON(now time.Time, level Level, msg string) []byte {
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for _, f := range l.fields {
+		entry[f.key] = formatValue(f.value)
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{
+			"time":  now.Format(time.RFC3339Nano),
+			"level": level.String(),
+			"msg":   msg,
+			"error": fmt.Sprintf("无法序列化日志字段: %v", err),
+		})
+	}
+	return append(data, '\n')
+}
+
+// formatValue 将错误和时长转换为便于阅读的形式
+func formatValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return v
+	}
 }
 
 // FormatBytes 格式化字节数
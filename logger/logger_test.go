@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/config"
+)
+
+// newBufferLogger 返回输出到buf的日志记录器
+func newBufferLogger(buf *bytes.Buffer, json bool, level Level) *Logger {
+	l := &Logger{sink: &sink{out: buf, json: json}}
+	l.SetLevel(level)
+	return l
+}
+
+func TestSetDebugRestoresConfiguredLevel(t *testing.T) {
+	tests := []struct {
+		configured string
+		want       Level
+	}{
+		{"info", LevelInfo},
+		{"warn", LevelWarn},
+		{"error", LevelError},
+		{"debug", LevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.configured, func(t *testing.T) {
+			l, err := NewFromConfig(config.LogConfig{Level: tt.configured, DisableConsole: true})
+			if err != nil {
+				t.Fatalf("NewFromConfig: %v", err)
+			}
+
+			l.SetDebug(true)
+			if !l.IsDebug() {
+				t.Fatal("开启调试模式后 IsDebug = false")
+			}
+			l.SetDebug(false)
+			if got := l.GetLevel(); got != tt.want {
+				t.Errorf("关闭调试模式后级别 = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	var buf bytes.Buffer
+	l := newBufferLogger(&buf, false, LevelWarn)
+
+	l.Debug("调试")
+	l.Info("信息")
+	l.Warn("警告")
+	l.Error("错误")
+
+	out := buf.String()
+	if strings.Contains(out, "调试") || strings.Contains(out, "信息") {
+		t.Errorf("低于最低级别的日志被输出: %q", out)
+	}
+	if !strings.Contains(out, "[WARN] 警告") || !strings.Contains(out, "[ERROR] 错误") {
+		t.Errorf("输出 = %q", out)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := newBufferLogger(&buf, false, LevelInfo)
+
+	l.With("hash", "abc", "path", "/a b", "empty", "").Info("下载 %d 个文件\n", 3)
+
+	line := buf.String()
+	want := `[INFO] 下载 3 个文件 hash=abc path="/a b" empty=""` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Errorf("输出 = %q, want 以 %q 结尾", line, want)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := newBufferLogger(&buf, true, LevelInfo)
+
+	l.With("err", errors.New("连接超时"), "duration", 1500*time.Millisecond, "count", 2).Warn("同步失败: %s", "center")
+	l.With("odd").Error("只有键")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("输出 %d 行, want 2: %q", len(lines), buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("无法解析JSON日志 %q: %v", lines[0], err)
+	}
+	want := map[string]interface{}{
+		"level":    "warn",
+		"msg":      "同步失败: center",
+		"err":      "连接超时",
+		"duration": "1.5s",
+		"count":    float64(2),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("time = %v: %v", entry["time"], err)
+	}
+
+	// 缺少值的键记录在!BADKEY下
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("无法解析JSON日志 %q: %v", lines[1], err)
+	}
+	if entry["!BADKEY"] != "odd" {
+		t.Errorf("!BADKEY = %v, want odd", entry["!BADKEY"])
+	}
+}
+
+func TestJSONUnsupportedValue(t *testing.T) {
+	var buf bytes.Buffer
+	l := newBufferLogger(&buf, true, LevelInfo)
+
+	// 无法序列化的字段不应丢失日志本身
+	l.With("ch", make(chan int)).Info("消息")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("无法解析JSON日志 %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "消息" || entry["error"] == nil {
+		t.Errorf("日志 = %v", entry)
+	}
+}
+
+func TestNewFromConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.LogConfig
+	}{
+		{"未知级别", config.LogConfig{Level: "verbose"}},
+		{"未知格式", config.LogConfig{Format: "xml"}},
+		{"不支持的编码", config.LogConfig{Encoding: "gbk"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFromConfig(tt.cfg); err == nil {
+				t.Error("NewFromConfig 应当返回错误")
+			}
+		})
+	}
+}
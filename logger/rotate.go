@@ -0,0 +1,185 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxSizeMB 日志文件默认的轮转大小
+	defaultMaxSizeMB = 100
+	// backupTimeFormat 轮转后文件名中的时间格式
+	backupTimeFormat = "20060102T150405.000"
+)
+
+// RotatingWriter 按大小轮转的日志文件，轮转后的文件按数量和保留天数清理。所有方法均可并发调用
+type RotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingWriter 打开日志文件，文件超过maxSizeMB时轮转，
+// maxBackups和maxAgeDays为0时不按数量或天数清理旧文件
+func NewRotatingWriter(path string, maxSizeMB, maxBackups, maxAgeDays int) (*RotatingWriter, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write 写入日志，写入后超过大小限制时先轮转
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即轮转日志文件
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+// Close 关闭日志文件
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open 以追加方式打开日志文件
+func (w *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("无法创建日志目录: %w", err)
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("无法打开日志文件 %s: %w", w.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("无法读取日志文件信息: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate 将当前文件重命名为带时间戳的备份并打开新文件，调用方需持有w.mu
+func (w *RotatingWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("无法关闭日志文件: %w", err)
+		}
+		w.file = nil
+	}
+
+	if err := os.Rename(w.path, w.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("无法轮转日志文件: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.prune()
+	return nil
+}
+
+// backupName 返回轮转文件名，如 access-20240102T150405.000.log
+func (w *RotatingWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	return fmt.Sprintf("%s-%s%s", base, t.Format(backupTimeFormat), ext)
+}
+
+// prune 删除超出数量或保留天数的轮转文件
+func (w *RotatingWriter) prune() {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(w.path)
+	prefix := filepath.Base(strings.TrimSuffix(w.path, ext)) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(w.path))
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(filepath.Dir(w.path), name), time: t})
+	}
+
+	// 新的在前
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	for i, b := range backups {
+		expired := w.maxAge > 0 && time.Since(b.time) > w.maxAge
+		overflow := w.maxBackups > 0 && i >= w.maxBackups
+		if expired || overflow {
+			os.Remove(b.path)
+		}
+	}
+}
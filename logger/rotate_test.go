@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listLogs 返回目录中的文件名
+func listLogs(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// newTestWriter 创建maxSize为size字节的RotatingWriter
+func newTestWriter(t *testing.T, path string, size int64, maxBackups, maxAgeDays int) *RotatingWriter {
+	t.Helper()
+	w, err := NewRotatingWriter(path, 1, maxBackups, maxAgeDays)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter: %v", err)
+	}
+	w.maxSize = size
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestRotatingWriterRotatesOnSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	w := newTestWriter(t, path, 10, 0, 0)
+
+	for _, line := range []string{"12345\n", "67890\n", "abcde\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		// 保证轮转文件名中的时间戳不同
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// 写入后超过大小限制时先轮转，单条日志不会被拆分
+	names := listLogs(t, dir)
+	if len(names) != 3 {
+		t.Fatalf("日志文件 = %v, want 当前文件和2个轮转文件", names)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(current) != "abcde\n" {
+		t.Errorf("当前文件 = %q, want %q", current, "abcde\n")
+	}
+	for _, name := range names {
+		if name != "app.log" && (!strings.HasPrefix(name, "app-") || !strings.HasSuffix(name, ".log")) {
+			t.Errorf("轮转文件名 = %s", name)
+		}
+	}
+}
+
+func TestRotatingWriterAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// 重新打开时继续计算已有内容的大小
+	w := newTestWriter(t, path, 1024, 0, 0)
+	if w.size != 4 {
+		t.Errorf("size = %d, want 4", w.size)
+	}
+	w.Write([]byte("new\n"))
+	if data, _ := os.ReadFile(path); string(data) != "old\nnew\n" {
+		t.Errorf("文件内容 = %q", data)
+	}
+}
+
+func TestRotatingWriterPrunesByCount(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	w := newTestWriter(t, path, 1024, 2, 0)
+
+	// 预先放置几个旧的轮转文件和一个无关文件
+	now := time.Now()
+	var backups []string
+	for i := 1; i <= 3; i++ {
+		name := w.backupName(now.Add(-time.Duration(i) * time.Minute))
+		backups = append(backups, filepath.Base(name))
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "app-notes.log"), []byte("x"), 0644)
+
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	// 保留最新的2个轮转文件：刚轮转的和1分钟前的
+	names := listLogs(t, dir)
+	if len(names) != 4 {
+		t.Fatalf("日志文件 = %v, want 4个", names)
+	}
+	for _, name := range []string{"app.log", "app-notes.log", backups[0]} {
+		if !slices.Contains(names, name) {
+			t.Errorf("%s 被删除: %v", name, names)
+		}
+	}
+	for _, name := range backups[1:] {
+		if slices.Contains(names, name) {
+			t.Errorf("%s 未被清理: %v", name, names)
+		}
+	}
+}
+
+func TestRotatingWriterPrunesByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	w := newTestWriter(t, path, 1024, 0, 7)
+
+	recent := w.backupName(time.Now().Add(-24 * time.Hour))
+	expired := w.backupName(time.Now().Add(-8 * 24 * time.Hour))
+	for _, name := range []string{recent, expired} {
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("保留期内的文件被删除: %v", err)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("过期的文件未被清理: %v", err)
+	}
+	if names := listLogs(t, dir); len(names) != 3 {
+		t.Errorf("日志文件 = %v, want 3个", names)
+	}
+}
+
+func TestRotatingWriterClosed(t *testing.T) {
+	w := newTestWriter(t, filepath.Join(t.TempDir(), "app.log"), 1024, 0, 0)
+	w.Close()
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("关闭后 Write 应当返回错误")
+	}
+}
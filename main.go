@@ -2,92 +2,211 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
+	"github.com/uright008/go-openbmclapi-reborn/admin"
 	"github.com/uright008/go-openbmclapi-reborn/cluster"
 	"github.com/uright008/go-openbmclapi-reborn/config"
 	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
 	"github.com/uright008/go-openbmclapi-reborn/server"
+	"github.com/uright008/go-openbmclapi-reborn/supervisor"
+	"github.com/uright008/go-openbmclapi-reborn/upnp"
+	"github.com/uright008/go-openbmclapi-reborn/utils"
 )
 
+// shutdownTimeout 关闭时等待各组件停止的总时长
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	// 加载配置
+	// 加载配置，配置加载完成前使用标准输出记录日志
+	bootLogger := logger.New(false)
 	cfg, err := config.Load("config.toml")
+	if errors.Is(err, config.ErrDefaultConfigCreated) {
+		bootLogger.Info("%v", err)
+		os.Exit(1)
+	}
 	if err != nil {
-		fmt.Printf("无法加载配置: %v\n", err)
+		bootLogger.Fatal("无法加载配置: %v", err)
+	}
+
+	// 根据配置初始化日志记录器
+	appLogger, err := logger.NewFromConfig(cfg.Log)
+	if err != nil {
+		bootLogger.Fatal("无法初始化日志: %v", err)
+	}
+	defer appLogger.Close()
+
+	if err := run(cfg, appLogger); err != nil {
+		appLogger.Error("%v", err)
+		appLogger.Close()
 		os.Exit(1)
 	}
+	appLogger.Info("服务器已关闭")
+}
+
+// run 启动所有组件并阻塞，直到收到关闭信号或组件异常退出。
+// 每个需要清理的资源在创建后立即加入组件组，启动阶段失败时同样按相反顺序清理后返回错误
+func run(cfg *config.Config, appLogger *logger.Logger) error {
+	// 收到SIGINT/SIGTERM时取消上下文，启动阶段的初始化和同步随之中止，之后按顺序关闭各组件
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 各组件按添加顺序启动，按相反顺序停止
+	group := supervisor.NewGroup(appLogger)
+	fail := func(err error) error {
+		group.Stop(shutdownTimeout)
+		return err
+	}
 
-	// 初始化日志记录器
-	// 根据配置中的日志级别判断是否开启调试模式
-	debugMode := cfg.Log.Level == "debug"
-	appLogger := logger.New(debugMode)
+	// 设置UPnP端口映射，映射最后删除
+	var upnpIP string
+	if cfg.Features.EnableUPNP {
+		mapper, externalIP, err := upnp.SetupUPnP(cfg.Cluster.Port, cfg.Cluster.PublicPort, appLogger)
+		if err != nil {
+			appLogger.Error("无法设置UPnP端口映射: %v", err)
+		} else {
+			upnpIP = externalIP
+			group.Add("UPnP端口映射", nil, func(context.Context) error {
+				return mapper.Close()
+			})
+		}
+	}
+
+	// 依次尝试配置的地址、UPnP外部地址、网卡地址和HTTP回显服务，配置了地址时直接使用；
+	// 未配置回显服务时不向外部服务查询
+	detectors := []utils.IPDetector{
+		utils.StaticIP(cfg.Cluster.IP),
+		utils.UPnPIP(func() (string, error) {
+			if upnpIP == "" {
+				return "", errors.New("未启用UPnP")
+			}
+			return upnpIP, nil
+		}),
+		utils.InterfaceIP(nil),
+	}
+	if cfg.Cluster.IPEchoURL != "" {
+		detectors = append(detectors, utils.HTTPEchoIP(nil, cfg.Cluster.IPEchoURL))
+	}
+	publicIP, err := utils.DetectPublicIP(detectors...)
+	if err != nil {
+		appLogger.Warn("%v，将由中心服务器使用连接来源地址", err)
+	} else if publicIP != cfg.Cluster.IP {
+		cfg.Cluster.IP = publicIP
+		appLogger.Info("自动获取的公网地址: %s", publicIP)
+	}
 
 	// 创建集群实例
 	appCluster, err := cluster.NewCluster(cfg, appLogger)
 	if err != nil {
-		appLogger.Fatal("无法创建集群实例: %v", err)
+		return fail(fmt.Errorf("无法创建集群实例: %w", err))
 	}
 
+	// 断开中心服务器连接，停止证书续期和令牌刷新
+	group.Add("集群", nil, appCluster.Close)
+
 	// 初始化集群
-	err = appCluster.Init()
+	err = appCluster.Init(ctx)
 	if err != nil {
-		appLogger.Fatal("无法初始化集群: %v", err)
+		return fail(fmt.Errorf("无法初始化集群: %w", err))
 	}
 
 	// 连接到中心服务器
-	err = appCluster.Connect()
+	err = appCluster.Connect(ctx)
 	if err != nil {
-		appLogger.Fatal("无法连接到中心服务器: %v", err)
+		return fail(fmt.Errorf("无法连接到中心服务器: %w", err))
+	}
+
+	// 未使用自有证书时向中心服务器申请证书，没有证书时无法提供HTTPS服务
+	if !cfg.Cluster.BYOC && (cfg.Security.SSLCert == "" || cfg.Security.SSLKey == "") {
+		err = appCluster.RequestCert(ctx)
+		if err != nil {
+			return fail(fmt.Errorf("无法获取证书: %w", err))
+		}
 	}
 
 	// 同步文件
-	err = appCluster.SyncFiles()
+	err = appCluster.SyncFiles(ctx)
 	if err != nil {
 		appLogger.Error("无法同步文件: %v", err)
 		// 不中断启动过程，但记录错误
 	}
 
-	// 创建并启动HTTP服务器
-	httpServer := server.NewServer(appCluster)
-	err = httpServer.Start(fmt.Sprintf(":%d", cfg.Cluster.Port))
-	if err != nil {
-		appLogger.Fatal("无法启动HTTP服务器: %v", err)
-	}
-
-	// 设置信号处理
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// 启动定期同步，关闭时等待正在进行的同步结束
+	appCluster.Scheduler().Start()
+	group.Add("同步调度器", nil, func(context.Context) error {
+		appCluster.Scheduler().Stop()
+		return nil
+	})
 
-	// 等待关闭信号
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		<-sigChan
-		appLogger.Info("收到关闭信号，正在关闭服务器...")
+	// HTTP服务器关闭时等待正在进行的下载完成
+	httpServer := server.NewServer(appCluster, appLogger)
+	group.Add("HTTP服务器", func() error {
+		err := httpServer.Start(fmt.Sprintf(":%d", cfg.Cluster.Port))
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}, httpServer.Stop)
 
-		// 创建一个5秒的上下文用于关闭服务器
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// 未配置单独的指标监听地址时指标只由管理接口提供
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen == "" && (!cfg.Admin.Enabled || cfg.Admin.Token == "") {
+		appLogger.Warn("管理接口未启用且未配置 metrics.listen，不会提供 Prometheus 指标")
+	}
 
-		// 关闭HTTP服务器
-		if err := httpServer.Stop(ctx); err != nil {
-			appLogger.Error("关闭HTTP服务器时出错: %v", err)
+	// 管理接口
+	if cfg.Admin.Enabled {
+		if cfg.Admin.Token == "" {
+			appLogger.Error("未配置管理接口令牌 admin.token，管理接口未启动")
+		} else {
+			adminServer := admin.NewServer(appCluster, cfg.Admin, appLogger)
+			group.Add("管理接口", func() error {
+				err := adminServer.Start()
+				if errors.Is(err, http.ErrServerClosed) {
+					return nil
+				}
+				return err
+			}, adminServer.Stop)
 		}
+	}
 
-		// 关闭集群
-		if err := appCluster.Close(); err != nil {
-			appLogger.Error("关闭集群时出错: %v", err)
+	// HTTP服务器开始监听后启用节点，开始接收中心服务器分配的流量；
+	// 关闭时最先禁用节点，避免中心服务器继续分配流量。
+	// nodeCtx只在停止该组件时取消，使正在等待确认的启用请求随之中止
+	nodeCtx, nodeCancel := context.WithCancel(context.Background())
+	group.Add("节点", func() error {
+		select {
+		case <-httpServer.Ready():
+		case <-nodeCtx.Done():
+			return nil
+		}
+		// 启用失败时按退避策略重试，直到成功、组件被停止或节点被手动禁用
+		for attempt := 0; !appCluster.ManuallyDisabled(); attempt++ {
+			err := appCluster.Enable(nodeCtx)
+			if err == nil || nodeCtx.Err() != nil {
+				break
+			}
+			appLogger.Error("无法启用节点 (第%d次): %v", attempt+1, err)
+			if !resilience.DefaultBackoff.Sleep(attempt, nodeCtx.Done()) {
+				break
+			}
 		}
-	}()
+		<-nodeCtx.Done()
+		return nil
+	}, func(ctx context.Context) error {
+		nodeCancel()
+		return appCluster.Disable(ctx)
+	})
 
 	appLogger.Info("服务器已启动，按 Ctrl+C 关闭")
-	wg.Wait()
-	appLogger.Info("服务器已关闭")
+	if err := group.Run(ctx, shutdownTimeout); err != nil {
+		return fmt.Errorf("服务器异常关闭: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,427 @@
+// Package mockcenter 提供一个进程内的模拟中心服务器，实现挑战认证、令牌、文件列表、
+// 文件下载以及Socket.IO控制通道，供集成测试在离线环境下走完完整的启动流程
+package mockcenter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/linkedin/goavro/v2"
+)
+
+// defaultTokenTTL 签发令牌的默认有效期
+const defaultTokenTTL = 24 * time.Hour
+
+// fileListSchema 文件列表的Avro Schema，与中心服务器保持一致
+const fileListSchema = `{
+	"type": "array",
+	"items": {
+	  "name": "FileListEntry",
+	  "type": "record",
+	  "fields": [
+		{"name": "path", "type": "string"},
+		{"name": "hash", "type": "string"},
+		{"name": "size", "type": "long"},
+		{"name": "mtime", "type": "long"}
+	  ]
+	}
+  }`
+
+// File 模拟中心服务器上的一个文件
+type File struct {
+	Path    string
+	Hash    string
+	Size    int64
+	MTime   int64
+	Content []byte
+}
+
+// KeepAlive 节点上报的一次保活信息
+type KeepAlive struct {
+	Time  time.Time `json:"time"`
+	Hits  int64     `json:"hits"`
+	Bytes int64     `json:"bytes"`
+}
+
+// Server 模拟中心服务器
+type Server struct {
+	*httptest.Server
+
+	clusterID string
+	secret    string
+
+	mu         sync.Mutex
+	challenges map[string]bool
+	tokens     map[string]bool
+	files      map[string]*File
+	enabled    bool
+	enables    []json.RawMessage
+	keepAlives []KeepAlive
+	events     []string
+	downloads  []string
+	cert       *certPair
+	conns      map[*socketConn]bool
+	// enableDelay 确认enable前的等待时间，模拟中心服务器对节点测速
+	enableDelay time.Duration
+	// generation 每次disable或断线时递增，使等待中的enable失效
+	generation int
+	// tokenTTL 签发令牌的有效期
+	tokenTTL time.Duration
+	// tokenStatus 非0时令牌接口直接返回该状态码，模拟中心服务器故障
+	tokenStatus int
+	// tokenRequests 令牌接口收到的请求，"fetch"为挑战认证，"refresh"为续期
+	tokenRequests []string
+	// downloadDelay 返回文件内容前的等待时间，模拟较慢的下载
+	downloadDelay time.Duration
+	// certFailures 之后还要拒绝的request-cert次数
+	certFailures int
+}
+
+// NewServer 启动一个模拟中心服务器，只接受给定集群ID和密钥的认证
+func NewServer(clusterID, secret string) *Server {
+	s := &Server{
+		clusterID:  clusterID,
+		secret:     secret,
+		challenges: make(map[string]bool),
+		tokens:     make(map[string]bool),
+		files:      make(map[string]*File),
+		conns:      make(map[*socketConn]bool),
+		tokenTTL:   defaultTokenTTL,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openbmclapi-agent/challenge", s.handleChallenge)
+	mux.HandleFunc("/openbmclapi-agent/token", s.handleToken)
+	mux.HandleFunc("/openbmclapi/files", s.requireToken(s.handleFiles))
+	mux.HandleFunc("/openbmclapi/download/", s.requireToken(s.handleDownload))
+	mux.HandleFunc("/socket.io/", s.handleSocket)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddFile 添加一个文件，返回其SHA-1哈希
+func (s *Server) AddFile(content []byte, mtime int64) string {
+	sum := sha1.Sum(content)
+	hash := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[hash] = &File{
+		Path:    "/openbmclapi/download/" + hash,
+		Hash:    hash,
+		Size:    int64(len(content)),
+		MTime:   mtime,
+		Content: content,
+	}
+	return hash
+}
+
+// RemoveFile 从文件列表中移除文件
+func (s *Server) RemoveFile(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, hash)
+}
+
+// SetEnableDelay 设置确认enable前的等待时间，期间收到disable或连接断开时拒绝启用
+func (s *Server) SetEnableDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enableDelay = d
+}
+
+// SetTokenTTL 设置之后签发的令牌的有效期
+func (s *Server) SetTokenTTL(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = d
+}
+
+// SetTokenStatus 使令牌接口返回指定的状态码，为0时恢复正常
+func (s *Server) SetTokenStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenStatus = status
+}
+
+// SetDownloadDelay 设置返回文件内容前的等待时间
+func (s *Server) SetDownloadDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloadDelay = d
+}
+
+// SetCertFailures 使之后的n次request-cert被拒绝
+func (s *Server) SetCertFailures(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certFailures = n
+}
+
+// TokenRequests 返回令牌接口收到的请求，"fetch"为挑战认证，"refresh"为续期
+func (s *Server) TokenRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokenRequests...)
+}
+
+// Kick 将节点标记为未启用，节点下一次保活时会被告知已被踢出
+func (s *Server) Kick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = false
+	s.generation++
+}
+
+// Disconnect 断开所有节点的Socket.IO连接
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.conn.Close()
+	}
+}
+
+// EventCount 返回收到名为event的事件的次数
+func (s *Server) EventCount(event string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, e := range s.events {
+		if e == event {
+			count++
+		}
+	}
+	return count
+}
+
+// Enabled 返回节点当前是否处于启用状态
+func (s *Server) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enabled
+}
+
+// EnableRequests 返回节点发送过的所有enable请求内容
+func (s *Server) EnableRequests() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.enables...)
+}
+
+// KeepAlives 返回节点上报过的所有保活信息
+func (s *Server) KeepAlives() []KeepAlive {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]KeepAlive(nil), s.keepAlives...)
+}
+
+// Events 返回按顺序收到的所有Socket.IO事件名
+func (s *Server) Events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+// Downloads 返回按顺序被下载过的文件哈希
+func (s *Server) Downloads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.downloads...)
+}
+
+// handleChallenge 签发挑战
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("clusterId") != s.clusterID {
+		http.Error(w, "unknown cluster", http.StatusNotFound)
+		return
+	}
+
+	challenge := randomHex(16)
+	s.mu.Lock()
+	s.challenges[challenge] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"challenge": challenge})
+}
+
+// handleToken 校验挑战签名或旧令牌后签发新令牌
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ClusterID string `json:"clusterId"`
+		Challenge string `json:"challenge"`
+		Signature string `json:"signature"`
+		Token     string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ClusterID != s.clusterID {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kind := "fetch"
+	if req.Token != "" {
+		kind = "refresh"
+	}
+	s.tokenRequests = append(s.tokenRequests, kind)
+	if s.tokenStatus != 0 {
+		http.Error(w, "unavailable", s.tokenStatus)
+		return
+	}
+
+	switch {
+	case req.Token != "":
+		// 使用旧令牌刷新
+		if !s.tokens[req.Token] {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+	case s.challenges[req.Challenge]:
+		delete(s.challenges, req.Challenge)
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write([]byte(req.Challenge))
+		if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(req.Signature)) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "invalid challenge", http.StatusForbidden)
+		return
+	}
+
+	token := randomHex(32)
+	s.tokens[token] = true
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token": token,
+		"ttl":   s.tokenTTL.Milliseconds(),
+	})
+}
+
+// requireToken 要求请求携带有效的Bearer令牌
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.validToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validToken 检查令牌是否由本服务器签发
+func (s *Server) validToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return token != "" && s.tokens[token]
+}
+
+// handleFiles 返回lastModified之后变化的文件列表，编码为zstd压缩的Avro
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	lastModified, _ := strconv.ParseInt(r.URL.Query().Get("lastModified"), 10, 64)
+
+	s.mu.Lock()
+	var records []interface{}
+	for _, file := range s.files {
+		if file.MTime > lastModified {
+			records = append(records, map[string]interface{}{
+				"path":  file.Path,
+				"hash":  file.Hash,
+				"size":  file.Size,
+				"mtime": file.MTime,
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	if len(records) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	codec, err := goavro.NewCodec(fileListSchema)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := codec.BinaryFromNative(nil, records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer encoder.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoder.EncodeAll(data, nil))
+}
+
+// handleDownload 返回文件内容
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/openbmclapi/download/")
+
+	s.mu.Lock()
+	file, ok := s.files[hash]
+	if ok {
+		s.downloads = append(s.downloads, hash)
+	}
+	delay := s.downloadDelay
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(file.Content)
+}
+
+// writeJSON 以JSON格式写出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// randomHex 生成n字节的随机十六进制字符串
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("mockcenter: 无法生成随机数: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
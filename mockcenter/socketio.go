@@ -0,0 +1,307 @@
+package mockcenter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingInterval Engine.IO心跳间隔
+const pingInterval = 25 * time.Second
+
+// certPair 签发给节点的证书
+type certPair struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// socketConn 一个节点的Socket.IO连接
+type socketConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// write 并发安全地写入一帧文本消息
+func (c *socketConn) write(packet string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(packet))
+}
+
+// ack 回复[[err, data]]格式的确认
+func (c *socketConn) ack(id string, errMsg interface{}, data interface{}) error {
+	payload, err := json.Marshal([]interface{}{[]interface{}{errMsg, data}})
+	if err != nil {
+		return err
+	}
+	return c.write("43" + id + string(payload))
+}
+
+// handleSocket 处理Socket.IO连接，仅支持websocket传输
+func (s *Server) handleSocket(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("transport") != "websocket" {
+		http.Error(w, "only websocket transport is supported", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := &socketConn{conn: ws}
+	defer ws.Close()
+
+	open, _ := json.Marshal(map[string]interface{}{
+		"sid":          randomHex(8),
+		"upgrades":     []string{},
+		"pingInterval": pingInterval.Milliseconds(),
+		"pingTimeout":  20000,
+		"maxPayload":   1000000,
+	})
+	if err := conn.write("0" + string(open)); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if conn.write("2") != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	// 连接断开时视为节点下线
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.enabled = false
+		s.generation++
+		s.mu.Unlock()
+	}()
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch msg[0] {
+		case '1':
+			return
+		case '2':
+			conn.write("3")
+		case '4':
+			if len(msg) < 2 {
+				continue
+			}
+			if !s.handlePacket(conn, msg[1], msg[2:]) {
+				return
+			}
+		}
+	}
+}
+
+// handlePacket 处理Socket.IO数据包，返回false表示应断开连接
+func (s *Server) handlePacket(conn *socketConn, typ byte, data []byte) bool {
+	switch typ {
+	case '0':
+		var auth struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(data, &auth)
+		if !s.validToken(auth.Token) {
+			conn.write(`44{"message":"invalid token"}`)
+			return false
+		}
+		conn.write(fmt.Sprintf(`40{"sid":"%s"}`, randomHex(8)))
+	case '1':
+		return false
+	case '2':
+		i := 0
+		for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+		id := string(data[:i])
+
+		var args []json.RawMessage
+		if err := json.Unmarshal(data[i:], &args); err != nil || len(args) == 0 {
+			return true
+		}
+		var event string
+		if err := json.Unmarshal(args[0], &event); err != nil {
+			return true
+		}
+		s.handleEvent(conn, id, event, args[1:])
+	}
+	return true
+}
+
+// handleEvent 处理节点发送的事件并回复确认
+func (s *Server) handleEvent(conn *socketConn, id, event string, args []json.RawMessage) {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+
+	var errMsg, data interface{}
+	switch event {
+	case "enable":
+		s.mu.Lock()
+		if len(args) > 0 {
+			s.enables = append(s.enables, args[0])
+		}
+		delay := s.enableDelay
+		if delay <= 0 {
+			s.enabled = true
+		}
+		generation := s.generation
+		s.mu.Unlock()
+
+		if delay > 0 {
+			// 测速期间不阻塞其他事件
+			go s.finishEnable(conn, id, generation, delay)
+			return
+		}
+		data = true
+	case "keep-alive":
+		var keepAlive KeepAlive
+		if len(args) > 0 {
+			json.Unmarshal(args[0], &keepAlive)
+		}
+		s.mu.Lock()
+		enabled := s.enabled
+		if enabled {
+			s.keepAlives = append(s.keepAlives, keepAlive)
+		}
+		s.mu.Unlock()
+		if enabled {
+			data = keepAlive.Time
+		} else {
+			// 未启用的节点保活会被踢下线
+			data = false
+		}
+	case "disable":
+		s.mu.Lock()
+		s.enabled = false
+		s.generation++
+		s.mu.Unlock()
+		data = true
+	case "request-cert":
+		s.mu.Lock()
+		refuse := s.certFailures > 0
+		if refuse {
+			s.certFailures--
+		}
+		s.mu.Unlock()
+		if refuse {
+			errMsg = map[string]string{"message": "certificate service unavailable"}
+			break
+		}
+
+		cert, err := s.certificate()
+		if err != nil {
+			errMsg = map[string]string{"message": err.Error()}
+		} else {
+			data = cert
+		}
+	default:
+		errMsg = map[string]string{"message": "unknown event " + strconv.Quote(event)}
+	}
+
+	if id != "" {
+		conn.ack(id, errMsg, data)
+	}
+}
+
+// finishEnable 等待delay后确认enable，期间节点被禁用或断线时拒绝启用
+func (s *Server) finishEnable(conn *socketConn, id string, generation int, delay time.Duration) {
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	current := s.generation == generation
+	if current {
+		s.enabled = true
+	}
+	s.mu.Unlock()
+
+	if id == "" {
+		return
+	}
+	if current {
+		conn.ack(id, nil, true)
+	} else {
+		conn.ack(id, map[string]string{"message": "enable superseded"}, nil)
+	}
+}
+
+// certificate 返回签发给节点的自签名证书，首次调用时生成
+func (s *Server) certificate() (*certPair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cert != nil {
+		return s.cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("无法生成私钥: %w", err)
+	}
+
+	host := s.clusterID + ".mock.openbmclapi"
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("无法生成证书: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("无法序列化私钥: %w", err)
+	}
+
+	s.cert = &certPair{
+		Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+	return s.cert, nil
+}
@@ -0,0 +1,118 @@
+// Package nginx 提供nginx模式所需的配置生成和访问日志统计。
+// nginx直接从文件存储目录提供文件，下载签名由本程序通过auth_request校验
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// LogFormat 生成的配置中访问日志的格式名称，Tailer按该格式解析
+const LogFormat = "openbmclapi"
+
+// Params 生成nginx配置所需的参数，路径均需为绝对路径
+type Params struct {
+	Port        int    // nginx对外监听的端口
+	Upstream    string // 本程序监听的内部地址
+	StoragePath string // 文件存储目录
+	AccessLog   string // nginx访问日志路径
+	CertFile    string // TLS证书，留空时使用HTTP
+	KeyFile     string // TLS私钥
+}
+
+// configTemplate 需要放在nginx的http块中
+var configTemplate = template.Must(template.New("nginx").Parse(`# 由 go-openbmclapi 自动生成，重新生成时会被覆盖
+# 请在nginx配置的 http { } 块中 include 本文件
+
+log_format {{.LogFormat}} '$msec $status $body_bytes_sent $request_method "$uri"';
+
+upstream openbmclapi_backend {
+    server {{.Upstream}};
+    keepalive 16;
+}
+
+server {
+{{- if .TLS}}
+    listen {{.Port}} ssl;
+    listen [::]:{{.Port}} ssl;
+    ssl_certificate {{.CertFile}};
+    ssl_certificate_key {{.KeyFile}};
+    ssl_protocols TLSv1.2 TLSv1.3;
+{{- else}}
+    listen {{.Port}};
+    listen [::]:{{.Port}};
+{{- end}}
+
+    access_log {{.AccessLog}} {{.LogFormat}};
+
+    # 文件按哈希的前两位分目录存放
+    location ~ "^/download/(?<prefix>[0-9a-fA-F]{2})(?<rest>[0-9a-fA-F]+)$" {
+        auth_request /auth;
+        default_type application/octet-stream;
+        alias {{.StoragePath}}/$prefix/$prefix$rest;
+    }
+
+    # 校验下载签名
+    location = /auth {
+        internal;
+        proxy_pass http://openbmclapi_backend/auth;
+        proxy_pass_request_body off;
+        proxy_set_header Content-Length "";
+        proxy_set_header X-Original-URI $request_uri;
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+    }
+
+    # 测速、健康检查等其余请求交给本程序处理
+    location / {
+        proxy_pass http://openbmclapi_backend;
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+        proxy_buffering off;
+    }
+}
+`))
+
+// Render 生成nginx配置
+func Render(p Params) ([]byte, error) {
+	data := struct {
+		Params
+		TLS       bool
+		LogFormat string
+	}{
+		Params:    p,
+		TLS:       p.CertFile != "" && p.KeyFile != "",
+		LogFormat: LogFormat,
+	}
+
+	var buf bytes.Buffer
+	if err := configTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("无法生成nginx配置: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteConfig 生成nginx配置并写入path，同时创建访问日志所在的目录
+func WriteConfig(path string, p Params) error {
+	content, err := Render(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("无法创建nginx配置目录: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p.AccessLog), 0755); err != nil {
+		return fmt.Errorf("无法创建nginx日志目录: %w", err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("无法写入nginx配置 %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	base := Params{
+		Port:        4000,
+		Upstream:    "127.0.0.1:4001",
+		StoragePath: "/data/cache",
+		AccessLog:   "/var/log/openbmclapi/access.log",
+	}
+	withTLS := base
+	withTLS.CertFile = "/data/ssl/cert.pem"
+	withTLS.KeyFile = "/data/ssl/key.pem"
+	certOnly := base
+	certOnly.CertFile = "/data/ssl/cert.pem"
+
+	common := []string{
+		`log_format openbmclapi '$msec $status $body_bytes_sent $request_method "$uri"';`,
+		"server 127.0.0.1:4001;",
+		"access_log /var/log/openbmclapi/access.log openbmclapi;",
+		"alias /data/cache/$prefix/$prefix$rest;",
+		"auth_request /auth;",
+	}
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    []string
+		notWant []string
+	}{
+		{"HTTP", base, []string{"listen 4000;", "listen [::]:4000;"}, []string{"ssl"}},
+		{"HTTPS", withTLS, []string{
+			"listen 4000 ssl;",
+			"listen [::]:4000 ssl;",
+			"ssl_certificate /data/ssl/cert.pem;",
+			"ssl_certificate_key /data/ssl/key.pem;",
+		}, nil},
+		{"缺少私钥时使用HTTP", certOnly, []string{"listen 4000;"}, []string{"ssl"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := Render(tt.params)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			config := string(content)
+			for _, want := range append(common, tt.want...) {
+				if !strings.Contains(config, want) {
+					t.Errorf("配置中缺少 %q", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(config, notWant) {
+					t.Errorf("配置中不应包含 %q", notWant)
+				}
+			}
+		})
+	}
+}
+
+// TestRenderLogFormatMatchesParser 生成的日志格式应能被ParseLine解析
+func TestRenderLogFormatMatchesParser(t *testing.T) {
+	content, err := Render(Params{Port: 4000, Upstream: "127.0.0.1:4001", StoragePath: "/data", AccessLog: "/tmp/access.log"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	// 按log_format替换变量得到一行日志
+	config := string(content)
+	start := strings.Index(config, "'")
+	end := strings.Index(config[start+1:], "'")
+	line := strings.NewReplacer(
+		"$msec", "1700000000.123",
+		"$status", "200",
+		"$body_bytes_sent", "1024",
+		"$request_method", "GET",
+		"$uri", "/download/aabbcc",
+	).Replace(config[start+1 : start+1+end])
+
+	if bytes, ok := ParseLine(line); !ok || bytes != 1024 {
+		t.Errorf("ParseLine(%q) = %d, %v, want 1024, true", line, bytes, ok)
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conf", "openbmclapi.conf")
+	params := Params{
+		Port:        4000,
+		Upstream:    "127.0.0.1:4001",
+		StoragePath: "/data",
+		AccessLog:   filepath.Join(dir, "logs", "access.log"),
+	}
+
+	if err := WriteConfig(path, params); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取配置: %v", err)
+	}
+	want, _ := Render(params)
+	if string(written) != string(want) {
+		t.Error("写入的配置与 Render 结果不一致")
+	}
+	if info, err := os.Stat(filepath.Dir(params.AccessLog)); err != nil || !info.IsDir() {
+		t.Errorf("未创建日志目录: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package nginx
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// pollInterval 检查访问日志新内容的间隔
+var pollInterval = time.Second
+
+// Tailer 持续读取nginx访问日志，将成功的下载请求上报为命中。
+// 日志被轮转或截断后会重新打开文件
+type Tailer struct {
+	path   string
+	onHit  func(bytes int64)
+	logger *logger.Logger
+
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewTailer 创建访问日志读取器，每条成功的下载请求调用一次onHit
+func NewTailer(path string, onHit func(bytes int64), logger *logger.Logger) *Tailer {
+	return &Tailer{
+		path:   path,
+		onHit:  onHit,
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start 从日志末尾开始读取，启动前已有的记录不会重复统计
+func (t *Tailer) Start() {
+	if t.open() {
+		t.offset, _ = t.file.Seek(0, io.SeekEnd)
+	}
+	go t.run()
+}
+
+// Stop 停止读取并关闭文件
+func (t *Tailer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+	})
+}
+
+// run 定期读取新增的日志行
+func (t *Tailer) run() {
+	defer close(t.done)
+	defer func() {
+		if t.file != nil {
+			t.file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.poll()
+		}
+	}
+}
+
+// open 打开日志文件，文件尚不存在时返回false
+func (t *Tailer) open() bool {
+	file, err := os.Open(t.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			t.logger.Warn("无法打开nginx访问日志 %s: %v", t.path, err)
+		}
+		return false
+	}
+	t.file = file
+	t.reader = bufio.NewReader(file)
+	t.offset = 0
+	return true
+}
+
+// poll 读取所有完整的新行，并处理日志轮转
+func (t *Tailer) poll() {
+	if t.file == nil && !t.open() {
+		return
+	}
+
+	t.readLines()
+
+	// 路径指向了新文件（被轮转）或文件变短（被截断）时重新打开
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return
+	}
+	current, err := t.file.Stat()
+	if err != nil || !os.SameFile(info, current) || info.Size() < t.offset {
+		t.file.Close()
+		t.file = nil
+		if t.open() {
+			t.readLines()
+		}
+	}
+}
+
+// readLines 读取到文件末尾，不完整的最后一行留到下次读取
+func (t *Tailer) readLines() {
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			// 回退未读完的部分
+			if len(line) > 0 {
+				t.file.Seek(t.offset, io.SeekStart)
+				t.reader.Reset(t.file)
+			}
+			return
+		}
+		t.offset += int64(len(line))
+
+		if bytes, ok := ParseLine(line); ok {
+			t.onHit(bytes)
+		}
+	}
+}
+
+// ParseLine 解析一行LogFormat格式的日志，返回成功下载请求的响应字节数
+func ParseLine(line string) (int64, bool) {
+	// $msec $status $body_bytes_sent $request_method "$uri"
+	// $uri 是解码后的路径，可能包含空格，因此按引号取出最后一个字段
+	line = strings.TrimSpace(line)
+	quote := strings.IndexByte(line, '"')
+	if quote < 0 || len(line) < quote+2 || line[len(line)-1] != '"' {
+		return 0, false
+	}
+	uri := line[quote+1 : len(line)-1]
+
+	fields := strings.Fields(line[:quote])
+	if len(fields) != 4 {
+		return 0, false
+	}
+
+	status, err := strconv.Atoi(fields[1])
+	if err != nil || (status != http.StatusOK && status != http.StatusPartialContent) {
+		return 0, false
+	}
+	if fields[3] != http.MethodGet {
+		return 0, false
+	}
+	if !strings.HasPrefix(uri, "/download/") {
+		return 0, false
+	}
+
+	bytes, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return bytes, true
+}
@@ -0,0 +1,165 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+func TestMain(m *testing.M) {
+	// 缩短轮询间隔，使测试在秒级内完成
+	pollInterval = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantBytes int64
+		wantOK    bool
+	}{
+		{"成功下载", `1700000000.123 200 1024 GET "/download/aabbcc"` + "\n", 1024, true},
+		{"部分内容", `1700000000.123 206 512 GET "/download/aabbcc"`, 512, true},
+		{"路径包含空格", `1700000000.123 200 10 GET "/download/a b c"`, 10, true},
+		{"非下载路径包含空格", `1700000000.123 200 10 GET "/measure/1 2"`, 0, false},
+		{"非下载路径", `1700000000.123 200 10 GET "/measure/1"`, 0, false},
+		{"HEAD请求", `1700000000.123 200 0 HEAD "/download/aabbcc"`, 0, false},
+		{"未修改", `1700000000.123 304 0 GET "/download/aabbcc"`, 0, false},
+		{"签名错误", `1700000000.123 403 0 GET "/download/aabbcc"`, 0, false},
+		{"字节数无效", `1700000000.123 200 abc GET "/download/aabbcc"`, 0, false},
+		{"缺少引号", `1700000000.123 200 10 GET /download/aabbcc`, 0, false},
+		{"引号未闭合", `1700000000.123 200 10 GET "/download/aabbcc`, 0, false},
+		{"字段过少", `200 10 GET "/download/aabbcc"`, 0, false},
+		{"空行", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytes, ok := ParseLine(tt.line)
+			if bytes != tt.wantBytes || ok != tt.wantOK {
+				t.Errorf("ParseLine(%q) = %d, %v, want %d, %v", tt.line, bytes, ok, tt.wantBytes, tt.wantOK)
+			}
+		})
+	}
+}
+
+// logLine 返回一条成功下载的日志
+func logLine(bytes int) string {
+	return "1700000000.123 200 " + strconv.Itoa(bytes) + ` GET "/download/aabbcc"` + "\n"
+}
+
+// appendLog 向日志文件追加内容
+func appendLog(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("打开日志: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("写入日志: %v", err)
+	}
+}
+
+// startTailer 启动读取path的Tailer，返回累计的命中次数和字节数
+func startTailer(t *testing.T, path string) (*atomic.Int64, *atomic.Int64) {
+	t.Helper()
+	var hits, bytes atomic.Int64
+	tailer := NewTailer(path, func(n int64) {
+		hits.Add(1)
+		bytes.Add(n)
+	}, logger.New(false))
+	tailer.Start()
+	t.Cleanup(tailer.Stop)
+	return &hits, &bytes
+}
+
+// waitFor 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTailerSkipsExistingLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendLog(t, path, logLine(100))
+
+	hits, bytes := startTailer(t, path)
+	appendLog(t, path, logLine(1)+logLine(2))
+
+	waitFor(t, "新日志", func() bool { return hits.Load() == 2 })
+	if got := bytes.Load(); got != 3 {
+		t.Errorf("字节数 = %d, want 3", got)
+	}
+}
+
+func TestTailerPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	hits, bytes := startTailer(t, path)
+
+	// 不完整的行留到写完后再统计
+	line := logLine(42)
+	appendLog(t, path, line[:10])
+	time.Sleep(5 * pollInterval)
+	if got := hits.Load(); got != 0 {
+		t.Fatalf("不完整的行被统计: %d", got)
+	}
+
+	appendLog(t, path, line[10:])
+	waitFor(t, "完整的行", func() bool { return hits.Load() == 1 })
+	if got := bytes.Load(); got != 42 {
+		t.Errorf("字节数 = %d, want 42", got)
+	}
+}
+
+func TestTailerTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	hits, bytes := startTailer(t, path)
+
+	appendLog(t, path, logLine(10)+logLine(20))
+	waitFor(t, "截断前的日志", func() bool { return hits.Load() == 2 })
+
+	// copytruncate方式轮转后从头读取
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	appendLog(t, path, logLine(5))
+
+	waitFor(t, "截断后的日志", func() bool { return hits.Load() == 3 })
+	if got := bytes.Load(); got != 35 {
+		t.Errorf("字节数 = %d, want 35", got)
+	}
+}
+
+func TestTailerRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	hits, bytes := startTailer(t, path)
+
+	appendLog(t, path, logLine(10))
+	waitFor(t, "轮转前的日志", func() bool { return hits.Load() == 1 })
+
+	// 重命名旧文件后创建新文件，旧文件中剩余的行和新文件中的行都应统计
+	if err := os.Rename(path, filepath.Join(dir, "access.log.1")); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	appendLog(t, filepath.Join(dir, "access.log.1"), logLine(20))
+	appendLog(t, path, logLine(30))
+
+	waitFor(t, "轮转后的日志", func() bool { return hits.Load() == 3 })
+	if got := bytes.Load(); got != 60 {
+		t.Errorf("字节数 = %d, want 60", got)
+	}
+}
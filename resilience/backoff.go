@@ -0,0 +1,66 @@
+// Package resilience 提供带抖动的指数退避和按依赖划分的熔断器，供访问中心服务器和存储时共用
+package resilience
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 带抖动的指数退避策略
+type Backoff struct {
+	// Base 第一次重试前的等待时间
+	Base time.Duration
+	// Max 等待时间的上限
+	Max time.Duration
+	// Factor 每次重试等待时间的增长倍数
+	Factor float64
+	// Jitter 随机抖动占等待时间的比例，取值0~1
+	Jitter float64
+}
+
+// DefaultBackoff 默认退避策略：1秒起，每次翻倍，最长5分钟，20%抖动
+var DefaultBackoff = Backoff{
+	Base:   time.Second,
+	Max:    5 * time.Minute,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+// Delay 返回第attempt次重试（从0开始）前应等待的时间
+func (b Backoff) Delay(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+
+	factor := b.Factor
+	if factor < 1 {
+		factor = 1
+	}
+
+	delay := float64(b.Base) * math.Pow(factor, float64(attempt))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	// 在[delay*(1-jitter), delay]之间随机取值，避免大量请求同时重试
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		delay -= delay * jitter * rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+// Sleep 等待第attempt次重试前的退避时间，stop被关闭时提前返回false
+func (b Backoff) Sleep(attempt int, stop <-chan struct{}) bool {
+	timer := time.NewTimer(b.Delay(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,66 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 10 * time.Second, Factor: 2}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{-1, time.Second},
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffFactorBelowOne(t *testing.T) {
+	b := Backoff{Base: time.Second, Factor: 0.5}
+	if got := b.Delay(3); got != time.Second {
+		t.Errorf("Delay(3) = %v, want %v", got, time.Second)
+	}
+}
+
+func TestBackoffJitterWithinCap(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 4 * time.Second, Factor: 2, Jitter: 0.5}
+	for attempt := 0; attempt < 10; attempt++ {
+		want := min(time.Second<<attempt, 4*time.Second)
+		for i := 0; i < 100; i++ {
+			if got := b.Delay(attempt); got > want || got < want/2 {
+				t.Fatalf("Delay(%d) = %v, want [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestBackoffSleep(t *testing.T) {
+	b := Backoff{Base: 10 * time.Millisecond, Factor: 1}
+	if !b.Sleep(0, nil) {
+		t.Error("未停止时 Sleep 应返回true")
+	}
+
+	// stop关闭时提前返回
+	long := Backoff{Base: time.Hour}
+	stop := make(chan struct{})
+	close(stop)
+	start := time.Now()
+	if long.Sleep(0, stop) {
+		t.Error("stop关闭后 Sleep 应返回false")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Sleep 等待了 %v", elapsed)
+	}
+}
@@ -0,0 +1,301 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 熔断器处于打开状态，请求被直接拒绝
+var ErrBreakerOpen = errors.New("熔断器已打开")
+
+// State 熔断器状态
+type State int
+
+const (
+	// StateClosed 正常放行请求
+	StateClosed State = iota
+	// StateOpen 拒绝所有请求，等待冷却
+	StateOpen
+	// StateHalfOpen 冷却结束，放行一个探测请求
+	StateHalfOpen
+)
+
+// String 返回状态名称
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// MarshalText 以状态名称序列化
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerStatus 熔断器的可观测状态
+type BreakerStatus struct {
+	Name                string    `json:"name"`
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	TotalFailures       int64     `json:"totalFailures"`
+	Trips               int64     `json:"trips"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastFailure         time.Time `json:"lastFailure"`
+	OpenUntil           time.Time `json:"openUntil"`
+}
+
+// Breaker 保护单个依赖的熔断器，连续失败达到阈值后打开，冷却后放行一个探测请求，
+// 探测成功则关闭，失败则以更长的冷却时间再次打开。所有方法均可并发调用
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  Backoff
+	onChange  func(name string, from, to State)
+
+	mu            sync.Mutex
+	state         State
+	failures      int
+	totalFailures int64
+	trips         int64
+	reopens       int
+	probing       bool
+	lastErr       error
+	lastFailure   time.Time
+	openUntil     time.Time
+}
+
+// Allow 检查是否允许发起请求，熔断器打开时返回ErrBreakerOpen
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Now().Before(b.openUntil) {
+			b.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrBreakerOpen, b.name)
+		}
+		// 冷却结束，放行一个探测请求
+		b.probing = true
+		notify := b.setStateLocked(StateHalfOpen)
+		b.mu.Unlock()
+		notify()
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrBreakerOpen, b.name)
+		}
+		b.probing = true
+	}
+
+	b.mu.Unlock()
+	return nil
+}
+
+// Success 记录一次成功的请求
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.reopens = 0
+	b.probing = false
+	notify := b.setStateLocked(StateClosed)
+	b.mu.Unlock()
+	notify()
+}
+
+// Failure 记录一次失败的请求，连续失败达到阈值或探测失败时打开熔断器
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	b.failures++
+	b.totalFailures++
+	b.lastErr = err
+	b.lastFailure = time.Now()
+
+	notify := func() {}
+	switch {
+	case b.state == StateHalfOpen:
+		b.reopens++
+		notify = b.tripLocked()
+	case b.state == StateClosed && b.failures >= b.threshold:
+		notify = b.tripLocked()
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// Release 放弃一次已放行的请求而不记录结果，用于请求最终没有访问该依赖的情况
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateHalfOpen {
+		b.probing = false
+	}
+}
+
+// Do 在熔断器保护下执行fn，并根据其返回值记录成功或失败。
+// fn因调用方取消而返回时不记录结果
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if errors.Is(err, context.Canceled) {
+			b.Release()
+			return err
+		}
+		b.Failure(err)
+		return err
+	}
+	b.Success()
+	return nil
+}
+
+// State 返回当前状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Status 返回当前的可观测状态
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		Name:                b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		TotalFailures:       b.totalFailures,
+		Trips:               b.trips,
+		LastFailure:         b.lastFailure,
+	}
+	if b.lastErr != nil {
+		status.LastError = b.lastErr.Error()
+	}
+	if b.state == StateOpen {
+		status.OpenUntil = b.openUntil
+	}
+	return status
+}
+
+// tripLocked 打开熔断器，冷却时间随连续打开次数指数增长
+func (b *Breaker) tripLocked() func() {
+	b.trips++
+	b.probing = false
+	b.openUntil = time.Now().Add(b.cooldown.Delay(b.reopens))
+	return b.setStateLocked(StateOpen)
+}
+
+// setStateLocked 切换状态，返回在释放锁之后调用的通知函数
+func (b *Breaker) setStateLocked(to State) func() {
+	from := b.state
+	if from == to {
+		return func() {}
+	}
+	b.state = to
+
+	onChange := b.onChange
+	if onChange == nil {
+		return func() {}
+	}
+	name := b.name
+	return func() { onChange(name, from, to) }
+}
+
+// Policy 按依赖名称管理一组熔断器，并共享退避策略
+type Policy struct {
+	// Backoff 重试的退避策略
+	Backoff Backoff
+
+	threshold int
+	cooldown  Backoff
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+	onChange func(name string, from, to State)
+}
+
+// NewPolicy 创建策略，依赖连续失败threshold次后熔断，熔断冷却时间按cooldown退避
+func NewPolicy(backoff Backoff, threshold int, cooldown Backoff) *Policy {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Policy{
+		Backoff:   backoff,
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*Breaker),
+	}
+}
+
+// OnStateChange 设置熔断器状态变化时的回调，回调在调用方的协程中执行，不持有任何锁
+func (p *Policy) OnStateChange(fn func(name string, from, to State)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onChange = fn
+	for _, b := range p.breakers {
+		b.mu.Lock()
+		b.onChange = fn
+		b.mu.Unlock()
+	}
+}
+
+// Breaker 返回指定依赖的熔断器，不存在时创建
+func (p *Policy) Breaker(name string) *Breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.breakers[name]
+	if !ok {
+		b = &Breaker{
+			name:      name,
+			threshold: p.threshold,
+			cooldown:  p.cooldown,
+			onChange:  p.onChange,
+		}
+		p.breakers[name] = b
+	}
+	return b
+}
+
+// Healthy 返回是否所有熔断器都处于关闭状态
+func (p *Policy) Healthy() bool {
+	for _, status := range p.Status() {
+		if status.State != StateClosed {
+			return false
+		}
+	}
+	return true
+}
+
+// Status 返回所有熔断器的状态，按名称排序
+func (p *Policy) Status() []BreakerStatus {
+	p.mu.Lock()
+	breakers := make([]*Breaker, 0, len(p.breakers))
+	for _, b := range p.breakers {
+		breakers = append(breakers, b)
+	}
+	p.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -0,0 +1,196 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("依赖故障")
+
+// testCooldown 测试使用的冷却时间，第一次20ms，之后翻倍
+var testCooldown = Backoff{Base: 20 * time.Millisecond, Max: time.Second, Factor: 2}
+
+// transitions 记录OnChange回调
+type transitions struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (tr *transitions) record(name string, from, to State) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.got = append(tr.got, fmt.Sprintf("%s:%s->%s", name, from, to))
+}
+
+func (tr *transitions) list() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return append([]string(nil), tr.got...)
+}
+
+func newTestBreaker(threshold int) (*Breaker, *transitions) {
+	tr := &transitions{}
+	p := NewPolicy(Backoff{}, threshold, testCooldown)
+	p.OnStateChange(tr.record)
+	return p.Breaker("center"), tr
+}
+
+func TestBreakerOpensAtThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		failures  int
+		want      State
+	}{
+		{"低于阈值", 3, 2, StateClosed},
+		{"达到阈值", 3, 3, StateOpen},
+		{"超过阈值", 3, 5, StateOpen},
+		{"阈值为1", 1, 1, StateOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBreaker(tt.threshold)
+			for i := 0; i < tt.failures; i++ {
+				b.Failure(errTest)
+			}
+			if got := b.State(); got != tt.want {
+				t.Errorf("State = %s, want %s", got, tt.want)
+			}
+			err := b.Allow()
+			if open := errors.Is(err, ErrBreakerOpen); open != (tt.want == StateOpen) {
+				t.Errorf("Allow = %v", err)
+			}
+		})
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker(3)
+	b.Failure(errTest)
+	b.Failure(errTest)
+	b.Success()
+	b.Failure(errTest)
+	b.Failure(errTest)
+	if got := b.State(); got != StateClosed {
+		t.Errorf("成功后连续失败计数应清零, State = %s", got)
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	b, tr := newTestBreaker(1)
+	b.Failure(errTest)
+
+	if err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("冷却期间 Allow = %v, want ErrBreakerOpen", err)
+	}
+
+	// 冷却结束后只放行一个探测请求
+	time.Sleep(testCooldown.Base + 10*time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("冷却结束后 Allow = %v", err)
+	}
+	if got := b.State(); got != StateHalfOpen {
+		t.Fatalf("State = %s, want %s", got, StateHalfOpen)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("探测期间 Allow = %v, want ErrBreakerOpen", err)
+	}
+
+	// 放弃探测后可以再次探测
+	b.Release()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("放弃探测后 Allow = %v", err)
+	}
+
+	b.Success()
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("探测成功后 State = %s, want %s", got, StateClosed)
+	}
+
+	want := []string{"center:closed->open", "center:open->half-open", "center:half-open->closed"}
+	if got := tr.list(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("OnChange = %v, want %v", got, want)
+	}
+}
+
+func TestBreakerProbeFailureReopensLonger(t *testing.T) {
+	b, tr := newTestBreaker(1)
+	b.Failure(errTest)
+	first := b.Status().OpenUntil.Sub(b.Status().LastFailure)
+
+	time.Sleep(testCooldown.Base + 10*time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	b.Failure(errTest)
+
+	status := b.Status()
+	if status.State != StateOpen || status.Trips != 2 {
+		t.Fatalf("探测失败后 Status = %+v", status)
+	}
+	if second := status.OpenUntil.Sub(status.LastFailure); second <= first {
+		t.Errorf("再次打开的冷却时间 %v 应长于 %v", second, first)
+	}
+	if got := tr.list(); len(got) != 3 || got[2] != "center:half-open->open" {
+		t.Errorf("OnChange = %v", got)
+	}
+}
+
+func TestBreakerDo(t *testing.T) {
+	b, _ := newTestBreaker(2)
+
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	// 调用方取消不计为失败
+	for i := 0; i < 3; i++ {
+		b.Do(func() error { return context.Canceled })
+	}
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("取消后 State = %s, want %s", got, StateClosed)
+	}
+
+	b.Do(func() error { return errTest })
+	b.Do(func() error { return errTest })
+	called := false
+	err := b.Do(func() error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrBreakerOpen) || called {
+		t.Errorf("熔断后 Do = %v, fn被调用 = %v", err, called)
+	}
+
+	status := b.Status()
+	if status.TotalFailures != 2 || status.LastError != errTest.Error() {
+		t.Errorf("Status = %+v", status)
+	}
+}
+
+func TestPolicyHealthy(t *testing.T) {
+	p := NewPolicy(Backoff{}, 1, testCooldown)
+	p.Breaker("storage").Success()
+	p.Breaker("center").Success()
+	if !p.Healthy() {
+		t.Fatal("所有熔断器关闭时应当健康")
+	}
+
+	p.Breaker("storage").Failure(errTest)
+	if p.Healthy() {
+		t.Error("有熔断器打开时不应健康")
+	}
+
+	statuses := p.Status()
+	if len(statuses) != 2 || statuses[0].Name != "center" || statuses[1].Name != "storage" {
+		t.Errorf("Status 应按名称排序: %+v", statuses)
+	}
+	// 同名依赖共用同一个熔断器
+	if p.Breaker("storage").State() != StateOpen {
+		t.Error("Breaker 应返回已存在的熔断器")
+	}
+}
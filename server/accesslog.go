@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// 文件的提供方式
+const (
+	modeDirect   = "direct"
+	modeRedirect = "redirect"
+)
+
+// 访问日志格式
+const (
+	accessFormatCombined = "combined"
+	accessFormatJSON     = "json"
+)
+
+// accessLogger 将请求记录为combined或JSON格式的访问日志
+type accessLogger struct {
+	mu      sync.Mutex
+	out     io.WriteCloser
+	json    bool
+	trusted []*net.IPNet
+}
+
+// accessEntryKey 请求上下文中访问日志条目的键
+type accessEntryKey struct{}
+
+// accessEntry 处理函数在请求过程中补充的访问日志信息
+type accessEntry struct {
+	mode string
+}
+
+// newAccessLogger 根据配置打开访问日志文件
+func newAccessLogger(cfg config.AccessLogConfig) (*accessLogger, error) {
+	al := &accessLogger{}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", accessFormatCombined:
+	case accessFormatJSON:
+		al.json = true
+	default:
+		return nil, fmt.Errorf("未知的访问日志格式: %s", cfg.Format)
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		network, err := parseTrustedProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		al.trusted = append(al.trusted, network)
+	}
+
+	out, err := logger.NewRotatingWriter(cfg.File, cfg.MaxSizeMB, cfg.MaxBackups, cfg.MaxAgeDays)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开访问日志: %w", err)
+	}
+	al.out = out
+
+	return al, nil
+}
+
+// parseTrustedProxy 解析单个IP或CIDR网段
+func parseTrustedProxy(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("无效的可信代理网段 %s: %w", s, err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("无效的可信代理地址: %s", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Close 关闭访问日志文件
+func (al *accessLogger) Close() error {
+	return al.out.Close()
+}
+
+// Middleware 记录经过的每个请求
+func (al *accessLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		cw := &countingWriter{ResponseWriter: w}
+
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		al.write(r, start, status, cw.bytes, entry.mode)
+	})
+}
+
+// setStorageMode 记录本次请求的文件提供方式，未经过访问日志中间件时忽略
+func setStorageMode(r *http.Request, mode string) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.mode = mode
+	}
+}
+
+// accessRecord JSON格式的访问日志
+type accessRecord struct {
+	Time      string  `json:"time"`
+	ClientIP  string  `json:"clientIp"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"durationMs"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"userAgent,omitempty"`
+	Mode      string  `json:"mode,omitempty"`
+}
+
+// write 写出一条访问日志
+func (al *accessLogger) write(r *http.Request, start time.Time, status int, bytes int64, mode string) {
+	duration := time.Since(start)
+	clientIP := al.clientIP(r)
+
+	var line []byte
+	if al.json {
+		data, err := json.Marshal(accessRecord{
+			Time:      start.Format(time.RFC3339Nano),
+			ClientIP:  clientIP,
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    status,
+			Bytes:     bytes,
+			Duration:  float64(duration.Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Mode:      mode,
+		})
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		// Combined Log Format，末尾追加耗时(秒)和提供方式
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %d %q %q %.3f %s\n",
+			clientIP,
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto,
+			status,
+			bytes,
+			orDash(r.Referer()),
+			orDash(r.UserAgent()),
+			duration.Seconds(),
+			orDash(mode),
+		))
+	}
+
+	al.mu.Lock()
+	al.out.Write(line)
+	al.mu.Unlock()
+}
+
+// clientIP 返回客户端地址，仅当直接连接方是可信代理时才采用X-Forwarded-For
+func (al *accessLogger) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !al.isTrusted(host) {
+		return host
+	}
+
+	// 从右向左跳过可信代理，第一个不可信的地址即为客户端
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" || net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !al.isTrusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// isTrusted 判断地址是否属于可信代理
+func (al *accessLogger) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range al.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// orDash 空字段在combined格式中记为"-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
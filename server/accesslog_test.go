@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// newTrustedLogger 返回信任proxies的访问日志记录器，不打开日志文件
+func newTrustedLogger(t *testing.T, proxies ...string) *accessLogger {
+	t.Helper()
+	al := &accessLogger{}
+	for _, proxy := range proxies {
+		network, err := parseTrustedProxy(proxy)
+		if err != nil {
+			t.Fatalf("parseTrustedProxy(%q): %v", proxy, err)
+		}
+		al.trusted = append(al.trusted, network)
+	}
+	return al
+}
+
+func TestClientIP(t *testing.T) {
+	al := newTrustedLogger(t, "10.0.0.0/8", "192.168.1.1", "fd00::/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{"无代理", "203.0.113.1:5000", "", "203.0.113.1"},
+		{"不可信的连接方忽略XFF", "203.0.113.1:5000", "198.51.100.1", "203.0.113.1"},
+		{"可信代理", "10.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"可信代理未带XFF", "10.0.0.1:5000", "", "10.0.0.1"},
+		{"多层可信代理", "10.0.0.1:5000", "198.51.100.1, 192.168.1.1, 10.0.0.2", "198.51.100.1"},
+		{"最右侧的不可信地址", "10.0.0.1:5000", "1.1.1.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"伪造的XFF在不可信地址左侧", "10.0.0.1:5000", "10.0.0.9, 198.51.100.1", "198.51.100.1"},
+		{"全部为可信代理", "10.0.0.1:5000", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"无效地址时停止", "10.0.0.1:5000", "198.51.100.1, unknown", "10.0.0.1"},
+		{"IPv6可信代理", "[fd00::1]:5000", "2001:db8::1", "2001:db8::1"},
+		{"没有端口的RemoteAddr", "203.0.113.1", "198.51.100.1", "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := al.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutTrustedProxies(t *testing.T) {
+	al := newTrustedLogger(t)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := al.clientIP(r); got != "127.0.0.1" {
+		t.Errorf("未配置可信代理时 clientIP = %s, want 127.0.0.1", got)
+	}
+}
+
+func TestParseTrustedProxy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{" 192.168.1.1 ", "192.168.1.1/32", false},
+		{"::1", "::1/128", false},
+		{"fd00::/8", "fd00::/8", false},
+		{"10.0.0.0/33", "", true},
+		{"proxy.local", "", true},
+	}
+
+	for _, tt := range tests {
+		network, err := parseTrustedProxy(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrustedProxy(%q) error = %v", tt.input, err)
+			continue
+		}
+		if !tt.wantErr && network.String() != tt.want {
+			t.Errorf("parseTrustedProxy(%q) = %s, want %s", tt.input, network, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// certExpiryWarning 证书剩余有效期低于该值时发出警告
+const certExpiryWarning = 7 * 24 * time.Hour
+
+// certCheckInterval 检查证书文件是否变化的间隔
+var certCheckInterval = 30 * time.Second
+
+// certReloader 从磁盘加载TLS证书，并在文件变化时热重载，已建立的连接不受影响
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *logger.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	certMod  time.Time
+	keyMod   time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newCertReloader 加载证书并开始监视文件变化
+func newCertReloader(certFile, keyFile string, logger *logger.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+// GetCertificate 供tls.Config使用，每次握手返回当前证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// NotAfter 返回当前证书的过期时间
+func (r *certReloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.notAfter
+}
+
+// Close 停止监视证书文件
+func (r *certReloader) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// reload 从磁盘读取证书和私钥
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("无法读取证书文件 %s: %w", r.certFile, err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("无法读取私钥文件 %s: %w", r.keyFile, err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("无法加载证书: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("无法解析证书: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.notAfter = leaf.NotAfter
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	r.mu.Unlock()
+
+	r.logExpiry()
+	return nil
+}
+
+// logExpiry 记录证书的有效期，临近过期时发出警告
+func (r *certReloader) logExpiry() {
+	notAfter := r.NotAfter()
+	remaining := time.Until(notAfter)
+
+	switch {
+	case remaining <= 0:
+		r.logger.Error("TLS证书已于 %s 过期", notAfter.Format(time.RFC3339))
+	case remaining < certExpiryWarning:
+		r.logger.Warn("TLS证书将于 %s 过期，剩余 %s", notAfter.Format(time.RFC3339), r.logger.FormatDuration(remaining))
+	default:
+		r.logger.Info("已加载TLS证书，有效期至 %s", notAfter.Format(time.RFC3339))
+	}
+}
+
+// changed 检查证书文件是否在上次加载后被修改
+func (r *certReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// watch 定期检查证书文件，变化时重新加载，失败时继续使用旧证书
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	lastWarn := time.Now()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if r.changed() {
+			r.logger.Info("检测到TLS证书文件变化，正在重新加载")
+			if err := r.reload(); err != nil {
+				r.logger.Error("重新加载TLS证书失败，继续使用旧证书: %v", err)
+			}
+			continue
+		}
+
+		// 临近过期时每天提醒一次
+		if time.Since(lastWarn) >= 24*time.Hour && time.Until(r.NotAfter()) < certExpiryWarning {
+			lastWarn = time.Now()
+			r.logExpiry()
+		}
+	}
+}
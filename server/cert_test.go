@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// writeCertPair 生成通用名为name、有效期至notAfter的自签名证书并写入文件
+func writeCertPair(t *testing.T, certFile, keyFile, name string, notAfter time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("写入私钥: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("写入证书: %v", err)
+	}
+}
+
+// touch 将文件的修改时间设为mtime，避免文件系统时间精度不足导致变化未被发现
+func touch(t *testing.T, mtime time.Time, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+}
+
+// leafName 返回reloader当前证书的通用名
+func leafName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil || cert == nil || cert.Leaf == nil {
+		t.Fatalf("GetCertificate = %v, %v", cert, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+// waitFor 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestReloader(t *testing.T) (*certReloader, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertPair(t, certFile, keyFile, "old.example", time.Now().Add(30*24*time.Hour))
+	touch(t, time.Now().Add(-time.Hour), certFile, keyFile)
+
+	r, err := newCertReloader(certFile, keyFile, logger.New(false))
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	t.Cleanup(r.Close)
+	return r, certFile, keyFile
+}
+
+func TestCertReloaderReloadsChangedFiles(t *testing.T) {
+	r, certFile, keyFile := newTestReloader(t)
+	if name := leafName(t, r); name != "old.example" {
+		t.Fatalf("初始证书 = %s, want old.example", name)
+	}
+
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	writeCertPair(t, certFile, keyFile, "new.example", notAfter)
+	touch(t, time.Now(), certFile, keyFile)
+
+	waitFor(t, "重新加载证书", func() bool { return leafName(t, r) == "new.example" })
+	if got := r.NotAfter(); !got.Equal(notAfter) {
+		t.Errorf("NotAfter = %v, want %v", got, notAfter)
+	}
+}
+
+func TestCertReloaderKeepsOldCertOnError(t *testing.T) {
+	r, certFile, keyFile := newTestReloader(t)
+
+	// 证书与私钥不匹配时继续使用旧证书
+	dir := t.TempDir()
+	otherKey := filepath.Join(dir, "key.pem")
+	writeCertPair(t, filepath.Join(dir, "cert.pem"), otherKey, "other.example", time.Now().Add(time.Hour))
+	data, err := os.ReadFile(otherKey)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := os.WriteFile(keyFile, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	touch(t, time.Now(), keyFile)
+
+	time.Sleep(10 * certCheckInterval)
+	if name := leafName(t, r); name != "old.example" {
+		t.Errorf("加载失败后证书 = %s, want old.example", name)
+	}
+
+	// 写入匹配的证书后恢复
+	writeCertPair(t, certFile, keyFile, "new.example", time.Now().Add(time.Hour))
+	touch(t, time.Now().Add(time.Minute), certFile, keyFile)
+	waitFor(t, "重新加载证书", func() bool { return leafName(t, r) == "new.example" })
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), logger.New(false)); err == nil {
+		t.Error("证书文件不存在时 newCertReloader 应当返回错误")
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// httpRequests 按处理函数和状态码统计的请求数
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by handler and status code.",
+	}, []string{"handler", "code"})
+
+	// httpBytes 按处理函数统计的响应字节数
+	httpBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "http",
+		Name:      "response_bytes_total",
+		Help:      "Response body bytes served by handler.",
+	}, []string{"handler"})
+
+	// httpDuration 按处理函数统计的请求耗时
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by handler.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"handler"})
+)
+
+// instrument 记录处理函数的请求数、响应字节数和耗时
+func instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingWriter{ResponseWriter: w}
+
+		next(cw, r)
+
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(name, strconv.Itoa(status)).Inc()
+		httpBytes.WithLabelValues(name).Add(float64(cw.bytes))
+		httpDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler 返回Prometheus指标的处理函数
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// startMetricsServer 在单独的地址上提供指标
+func (s *Server) startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+
+	s.metrics = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		s.logger.Info("Starting metrics server on %s", addr)
+		if err := s.metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("指标服务器异常退出: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/uright008/go-openbmclapi-reborn/nginx"
+)
+
+// startNginx 生成nginx配置并在内部地址上提供auth_request和其余接口，
+// 下载由nginx直接从文件存储目录提供，命中统计来自nginx的访问日志
+func (s *Server) startNginx() error {
+	cfg := s.cluster.Config
+	if cfg.Storage.Type != "file" {
+		return fmt.Errorf("nginx模式仅支持文件存储，当前存储类型: %s", cfg.Storage.Type)
+	}
+
+	storagePath, err := filepath.Abs(cfg.Storage.Path)
+	if err != nil {
+		return fmt.Errorf("无法解析存储路径: %w", err)
+	}
+	accessLog, err := filepath.Abs(cfg.Nginx.AccessLog)
+	if err != nil {
+		return fmt.Errorf("无法解析nginx访问日志路径: %w", err)
+	}
+
+	params := nginx.Params{
+		Port:        cfg.Cluster.Port,
+		Upstream:    cfg.Nginx.Listen,
+		StoragePath: storagePath,
+		AccessLog:   accessLog,
+	}
+	if certFile, keyFile := s.cluster.CertFiles(); certFile != "" && keyFile != "" {
+		if params.CertFile, err = filepath.Abs(certFile); err != nil {
+			return fmt.Errorf("无法解析证书路径: %w", err)
+		}
+		if params.KeyFile, err = filepath.Abs(keyFile); err != nil {
+			return fmt.Errorf("无法解析私钥路径: %w", err)
+		}
+	}
+
+	if err := nginx.WriteConfig(cfg.Nginx.ConfigPath, params); err != nil {
+		return err
+	}
+	s.logger.Info("已生成nginx配置 %s，请在nginx的http块中include该文件并重新加载nginx", cfg.Nginx.ConfigPath)
+
+	// Count hits from nginx's access log since downloads never reach us
+	s.tailer = nginx.NewTailer(accessLog, s.cluster.RecordHit, s.logger)
+	s.tailer.Start()
+
+	s.server.Addr = cfg.Nginx.Listen
+	s.logger.Info("Starting nginx backend on %s", cfg.Nginx.Listen)
+	return s.listenAndServe(false)
+}
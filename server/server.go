@@ -2,12 +2,25 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/uright008/go-openbmclapi-reborn/cluster"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/nginx"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
+	"github.com/uright008/go-openbmclapi-reborn/storage"
+	"github.com/uright008/go-openbmclapi-reborn/token"
 	"github.com/uright008/go-openbmclapi-reborn/utils"
 )
 
@@ -15,13 +28,42 @@ import (
 type Server struct {
 	cluster *cluster.Cluster
 	server  *http.Server
+	logger  *logger.Logger
+	certs   *certReloader
+	metrics *http.Server
+	access  *accessLogger
+	tailer  *nginx.Tailer
+
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 // New 创建新的HTTP服务器实例
-func NewServer(cluster *cluster.Cluster) *Server {
+func NewServer(cluster *cluster.Cluster, logger *logger.Logger) *Server {
 	return &Server{
 		cluster: cluster,
+		logger:  logger,
+		ready:   make(chan struct{}),
+	}
+}
+
+// Ready 返回在服务器开始监听后关闭的通道
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// listenAndServe 监听地址后通知Ready，再开始处理请求
+func (s *Server) listenAndServe(useTLS bool) error {
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	s.readyOnce.Do(func() { close(s.ready) })
+
+	if useTLS {
+		return s.server.ServeTLS(ln, "", "")
 	}
+	return s.server.Serve(ln)
 }
 
 // Start 启动HTTP服务器
@@ -29,38 +71,95 @@ func (s *Server) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Download route
-	mux.HandleFunc("/download/", s.handleDownload)
+	mux.HandleFunc("/download/", instrument("download", s.handleDownload))
+
+	// Bandwidth measurement route
+	mux.HandleFunc("/measure/", instrument("measure", s.handleMeasure))
 
 	// Health check route
 	mux.HandleFunc("/health", s.handleHealth)
 
+	// nginx auth_request route
+	if s.cluster.Config.Features.EnableNginx {
+		mux.HandleFunc("/auth", instrument("auth", s.handleAuth))
+	}
+
 	return mux
 }
 
 func (s *Server) Start(addr string) error {
-	mux := s.SetupRoutes()
+	var handler http.Handler = s.SetupRoutes()
+
+	// Access log, unless disabled by features.disable_access_log
+	if !s.cluster.Config.Features.DisableAccessLog {
+		access, err := newAccessLogger(s.cluster.Config.AccessLog)
+		if err != nil {
+			return err
+		}
+		s.access = access
+		handler = access.Middleware(handler)
+	}
 
 	s.server = &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: handler,
+	}
+
+	// Metrics are never served on the public port; without a separate
+	// listener they are served by the authenticated admin API
+	if metricsConfig := s.cluster.Config.Metrics; metricsConfig.Enabled && metricsConfig.Listen != "" {
+		s.startMetricsServer(metricsConfig.Listen)
+	}
+
+	// In nginx mode nginx serves the public port and proxies the rest to us
+	if s.cluster.Config.Features.EnableNginx {
+		return s.startNginx()
 	}
 
-	fmt.Printf("Starting server on %s\n", addr)
-	return s.server.ListenAndServe()
+	// Serve HTTPS when a certificate is configured or issued by the center
+	certFile, keyFile := s.cluster.CertFiles()
+	if certFile != "" && keyFile != "" {
+		certs, err := newCertReloader(certFile, keyFile, s.logger)
+		if err != nil {
+			return fmt.Errorf("无法加载TLS证书: %w", err)
+		}
+		s.certs = certs
+		s.server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+
+		s.logger.Info("Starting HTTPS server on %s", addr)
+		return s.listenAndServe(true)
+	}
+
+	s.logger.Info("Starting server on %s", addr)
+	return s.listenAndServe(false)
 }
 
 // Stop stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
+	if s.certs != nil {
+		s.certs.Close()
+	}
+	if s.metrics != nil {
+		s.metrics.Shutdown(ctx)
+	}
+	var err error
 	if s.server != nil {
-		return s.server.Shutdown(ctx)
+		err = s.server.Shutdown(ctx)
+	}
+	if s.tailer != nil {
+		s.tailer.Stop()
 	}
-	return nil
+	if s.access != nil {
+		s.access.Close()
+	}
+	return err
 }
 
 // handleDownload handles file download requests
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-
 	// Extract hash from URL
 	hash := r.URL.Path[len("/download/"):]
 	if hash == "" {
@@ -68,13 +167,8 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse query parameters
-	query := r.URL.Query()
-
-	// Check signature
-	// 注意: 这里我们假设cluster.Cluster有一个CheckSign方法，如果没有，我们需要实现它
-	// 或者使用utils包中的签名验证函数
-	if !utils.VerifySignature(s.cluster.Config.Cluster.Secret, hash, query.Get("sign")) {
+	// Check signature and expiry
+	if !s.verifyRequest(r, hash) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -83,7 +177,7 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	storage := s.cluster.Storage
 
 	// Try to get the file from storage
-	fileReader, err := storage.Get(hash)
+	fileReader, err := storage.Get(r.Context(), hash)
 	if err != nil {
 		// File does not exist in storage
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -95,44 +189,114 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	if redirectReader, ok := fileReader.(interface{ GetRedirectURL() string }); ok {
 		// For WebDAV storage, redirect to the actual file location
 		redirectURL := redirectReader.GetRedirectURL()
+		setStorageMode(r, modeRedirect)
 		http.Redirect(w, r, redirectURL, http.StatusFound)
+
+		// Redirects are billed by the size of the file the client fetches
+		size, _ := s.cluster.FileSize(hash)
+		s.cluster.RecordHit(size)
 		return
 	}
 
 	// For regular file storage, serve the file content
-	// Record hit for statistics
-	// 注意: 这里我们假设cluster.Cluster有一个RecordHit方法，如果没有，我们需要实现它
-	// s.cluster.RecordHit(0) // TODO: Get actual file size
+	setStorageMode(r, modeDirect)
+	cw := &countingWriter{ResponseWriter: w}
+	if seeker, ok := fileReader.(io.ReadSeeker); ok {
+		s.serveContent(cw, r, hash, seeker)
+	} else {
+		cw.Header().Set("Content-Type", "application/octet-stream")
+		_, err = io.Copy(cw, fileReader)
+		if err != nil && cw.status == 0 {
+			http.Error(cw, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// Copy file content to response
-	_, err = io.Copy(w, fileReader)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Record hit with the bytes actually written, so partial responses count correctly
+	if r.Method != http.MethodHead && (cw.status == http.StatusOK || cw.status == http.StatusPartialContent) {
+		s.cluster.RecordHit(cw.bytes)
+	}
+}
+
+// serveContent 提供本地文件内容，支持Range、HEAD以及条件请求
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, hash string, content io.ReadSeeker) {
+	// The content hash never changes for a given file, so it makes a strong ETag
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	var modTime time.Time
+	if statter, ok := content.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := statter.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+
+	// ServeContent handles Range, multi-range, HEAD, If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// handleMeasure 处理中心服务器的测速请求，返回指定大小(MB)的数据
+func (s *Server) handleMeasure(w http.ResponseWriter, r *http.Request) {
+	// The signature covers the full request path, e.g. /measure/10
+	if !s.verifyRequest(r, r.URL.Path) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	size, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/measure/"))
+	if err != nil || size <= 0 || size > storage.MeasureMaxSize {
+		http.Error(w, "Invalid size", http.StatusBadRequest)
 		return
 	}
 
-	// Log request
-	duration := time.Since(startTime)
-	fmt.Printf("[%s] %s %s %v\n", r.Method, r.URL.Path, "200", duration)
+	// Redirect-based storages serve the measure file themselves
+	if measurer, ok := s.cluster.Storage.(interface {
+		MeasureURL(ctx context.Context, size int) (string, error)
+	}); ok {
+		redirectURL, err := measurer.MeasureURL(r.Context(), size)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		setStorageMode(r, modeRedirect)
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+		return
+	}
+
+	// Stream generated data so the whole payload is never held in memory
+	setStorageMode(r, modeDirect)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(storage.MeasureSize(size), 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, storage.NewMeasureReader(size))
 }
 
-// handleAuth 处理认证请求
+// handleAuth 处理nginx的auth_request子请求，签名位于原始请求的查询参数中
 func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	// 从X-Original-URI头中提取原始URI
 	originalURI := r.Header.Get("X-Original-URI")
 	if originalURI == "" {
-		originalURI = r.URL.Path
+		originalURI = r.URL.RequestURI()
+	}
+
+	original, err := url.ParseRequestURI(originalURI)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
 	}
 
 	// 从URI中提取哈希值
-	hash := utils.ExtractHashFromPath(originalURI)
+	hash := utils.ExtractHashFromPath(original.Path)
 	if hash == "" {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
 	// 验证请求签名
-	if !s.verifyRequest(r, hash) {
+	if !utils.CheckSignQuery(s.cluster.Config.Cluster.Secret, hash, original.Query()) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -141,24 +305,59 @@ func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// verifyRequest 验证请求签名
+// verifyRequest 验证请求签名及其过期时间
 func (s *Server) verifyRequest(r *http.Request, hash string) bool {
-	// 获取查询参数
-	query := r.URL.Query()
+	return utils.CheckSignQuery(s.cluster.Config.Cluster.Secret, hash, r.URL.Query())
+}
 
-	// 获取签名
-	signature := query.Get("sign")
-	if signature == "" {
-		return false
-	}
+// healthResponse 健康检查返回的信息，接口无需认证，错误详情只通过管理接口提供
+type healthResponse struct {
+	Status      string             `json:"status"`
+	Certificate *certificateHealth `json:"certificate,omitempty"`
+	Token       token.State        `json:"token"`
+	Breakers    []breakerHealth    `json:"breakers"`
+}
 
-	// 验证签名
-	return utils.VerifySignature(s.cluster.Config.Cluster.Secret, hash, signature)
+// breakerHealth 依赖熔断器的状态
+type breakerHealth struct {
+	Name  string           `json:"name"`
+	State resilience.State `json:"state"`
+}
+
+// certificateHealth TLS证书状态
+type certificateHealth struct {
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+	Expired  bool      `json:"expired"`
 }
 
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
+	resp := healthResponse{
+		Status:   "ok",
+		Token:    s.cluster.TokenState(),
+		Breakers: []breakerHealth{},
+	}
+	// 令牌错误可能包含中心服务器地址，只通过管理接口提供
+	resp.Token.LastError = ""
+	for _, breaker := range s.cluster.BreakerStatus() {
+		resp.Breakers = append(resp.Breakers, breakerHealth{Name: breaker.Name, State: breaker.State})
+	}
+	if !s.cluster.Healthy() || !resp.Token.Valid {
+		resp.Status = "degraded"
+	}
+
+	if s.certs != nil {
+		notAfter := s.certs.NotAfter()
+		remaining := time.Until(notAfter)
+		resp.Certificate = &certificateHealth{
+			NotAfter: notAfter,
+			DaysLeft: int(remaining.Hours() / 24),
+			Expired:  remaining <= 0,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	json.NewEncoder(w).Encode(resp)
 }
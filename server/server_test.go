@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/cluster"
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/mockcenter"
+	"github.com/uright008/go-openbmclapi-reborn/storage"
+	"github.com/uright008/go-openbmclapi-reborn/utils"
+)
+
+const testHash = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+
+func TestMain(m *testing.M) {
+	// 缩短证书文件的检查间隔，使测试在秒级内完成
+	certCheckInterval = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+const testSecret = "test-secret"
+
+// newTestConfig 返回使用临时目录文件存储的配置
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	return &config.Config{
+		Cluster: config.ClusterConfig{
+			ID:        "test-cluster",
+			Secret:    testSecret,
+			Port:      4000,
+			ServerURL: "http://127.0.0.1:1",
+		},
+		Storage: config.StorageConfig{Type: "file", Path: t.TempDir()},
+		System:  config.SystemConfig{DataDir: t.TempDir()},
+		Sync:    config.SyncConfig{IntervalMinutes: 10, DisableThreshold: 100},
+		Metrics: config.MetricsConfig{Enabled: true},
+	}
+}
+
+// newTestServer 创建未连接中心服务器的集群及其HTTP服务器
+func newTestServer(t *testing.T, cfg *config.Config) (*Server, *cluster.Cluster) {
+	t.Helper()
+	c, err := cluster.NewCluster(cfg, logger.New(false))
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	return NewServer(c, logger.New(false)), c
+}
+
+func TestMetricsNotServedOnPublicPort(t *testing.T) {
+	s, _ := newTestServer(t, newTestConfig(t))
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("公开端口的 /metrics 状态码 = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// downloadPath 返回带有效签名的下载路径
+func downloadPath(hash string) string {
+	sign, expire := utils.SignDownload(testSecret, hash, time.Now().Add(time.Hour))
+	return "/download/" + hash + "?" + url.Values{"s": {sign}, "e": {expire}}.Encode()
+}
+
+func TestDownloadRedirectCountsHit(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Storage.Type = "webdav"
+	cfg.Storage.WebDAV = config.WebDAVConfig{Endpoint: "http://webdav.example", Path: "/data"}
+	s, c := newTestServer(t, cfg)
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, downloadPath(testHash), nil))
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("状态码 = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if location := rec.Header().Get("Location"); !strings.HasPrefix(location, "http://webdav.example/") {
+		t.Errorf("Location = %q", location)
+	}
+	if hits := c.Stats().Pending().Hits; hits != 1 {
+		t.Errorf("重定向后命中次数 = %d, want 1", hits)
+	}
+}
+
+// redirectStorage 将下载重定向到外部地址，模拟WebDAV等存储
+type redirectStorage struct {
+	storage.Storage
+}
+
+func (redirectStorage) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return redirectReader{url: "http://cdn.example/" + hash}, nil
+}
+
+// redirectReader 带重定向地址的空文件
+type redirectReader struct {
+	url string
+}
+
+func (redirectReader) Read([]byte) (int, error) { return 0, io.EOF }
+func (redirectReader) Close() error             { return nil }
+func (r redirectReader) GetRedirectURL() string { return r.url }
+
+func TestRedirectCountsBytesAfterRestart(t *testing.T) {
+	center := mockcenter.NewServer("test-cluster", testSecret)
+	defer center.Close()
+	content := "redirected file"
+	hash := center.AddFile([]byte(content), 1000)
+
+	cfg := newTestConfig(t)
+	cfg.Cluster.ServerURL = center.URL
+	cfg.Sync.MaxConcurrency = 4
+	ctx := context.Background()
+
+	// 首次运行同步文件并保存水位
+	_, first := newTestServer(t, cfg)
+	if err := first.SyncFiles(ctx); err != nil {
+		t.Fatalf("SyncFiles: %v", err)
+	}
+	first.Close(ctx)
+
+	// 重启后水位之前的文件不在增量列表中，仍应记录其大小
+	s, c := newTestServer(t, cfg)
+	defer c.Close(ctx)
+	if err := c.SyncFiles(ctx); err != nil {
+		t.Fatalf("SyncFiles: %v", err)
+	}
+	c.Storage = redirectStorage{c.Storage}
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, downloadPath(hash), nil))
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("状态码 = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if pending := c.Stats().Pending(); pending.Hits != 1 || pending.Bytes != int64(len(content)) {
+		t.Errorf("统计 = %+v, want 1次 %d字节", pending, len(content))
+	}
+}
+
+func TestDownloadCountsBytes(t *testing.T) {
+	s, c := newTestServer(t, newTestConfig(t))
+	if err := c.Storage.Put(context.Background(), testHash, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, downloadPath(testHash), nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatalf("响应 = %d %q", rec.Code, rec.Body.String())
+	}
+	if pending := c.Stats().Pending(); pending.Hits != 1 || pending.Bytes != 5 {
+		t.Errorf("统计 = %+v, want 1次 5字节", pending)
+	}
+}
+
+func TestDownloadConditionalAndRange(t *testing.T) {
+	const content = "0123456789"
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		// wantParts Content-Type或响应体中应包含的片段
+		wantParts []string
+		wantHits  int64
+	}{
+		{"单个Range", http.MethodGet, map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, []string{"2345"}, 1},
+		{"多个Range", http.MethodGet, map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusPartialContent, []string{"multipart", "01", "45"}, 1},
+		{"无法满足的Range", http.MethodGet, map[string]string{"Range": "bytes=100-200"}, http.StatusRequestedRangeNotSatisfiable, nil, 0},
+		{"HEAD", http.MethodHead, nil, http.StatusOK, nil, 0},
+		{"If-None-Match", http.MethodGet, map[string]string{"If-None-Match": `"` + testHash + `"`}, http.StatusNotModified, nil, 0},
+		{"If-Modified-Since", http.MethodGet, map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, http.StatusNotModified, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestServer(t, newTestConfig(t))
+			if err := c.Storage.Put(context.Background(), testHash, strings.NewReader(content)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			req := httptest.NewRequest(tt.method, downloadPath(testHash), nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			s.SetupRoutes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("状态码 = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			response := rec.Header().Get("Content-Type") + "\n" + rec.Body.String()
+			for _, part := range tt.wantParts {
+				if !strings.Contains(response, part) {
+					t.Errorf("响应 = %q, 应包含 %q", response, part)
+				}
+			}
+			if tt.method == http.MethodHead && rec.Body.Len() != 0 {
+				t.Errorf("HEAD 响应体 = %q", rec.Body.String())
+			}
+
+			// 只统计实际写出的响应体字节
+			pending := c.Stats().Pending()
+			wantBytes := int64(0)
+			if tt.wantHits > 0 {
+				wantBytes = int64(rec.Body.Len())
+			}
+			if pending.Hits != tt.wantHits || pending.Bytes != wantBytes {
+				t.Errorf("统计 = %+v, want %d次 %d字节", pending, tt.wantHits, wantBytes)
+			}
+		})
+	}
+}
+
+func TestDownloadRejectsBadSignature(t *testing.T) {
+	s, c := newTestServer(t, newTestConfig(t))
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download/"+testHash+"?s=bad&e=zzzzzzzz", nil))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("状态码 = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if hits := c.Stats().Pending().Hits; hits != 0 {
+		t.Errorf("签名错误的请求被计入命中: %d", hits)
+	}
+}
+
+func TestHealthHidesBreakerErrors(t *testing.T) {
+	s, c := newTestServer(t, newTestConfig(t))
+
+	// 中心服务器地址无法连接，同步失败会在熔断器中记录包含地址的错误
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Scheduler().RunOnce(ctx); err == nil {
+		t.Fatal("RunOnce 应当失败")
+	}
+
+	rec := httptest.NewRecorder()
+	s.SetupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("状态码 = %d", rec.Code)
+	}
+
+	var resp struct {
+		Breakers []map[string]interface{} `json:"breakers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("无法解析响应: %v", err)
+	}
+	if len(resp.Breakers) == 0 {
+		t.Fatal("响应中没有熔断器状态")
+	}
+	for _, breaker := range resp.Breakers {
+		if len(breaker) != 2 || breaker["name"] == nil || breaker["state"] == nil {
+			t.Errorf("熔断器状态包含多余的字段: %v", breaker)
+		}
+	}
+	if body := rec.Body.String(); strings.Contains(body, "127.0.0.1:1") {
+		t.Errorf("健康检查泄露了错误详情: %s", body)
+	}
+}
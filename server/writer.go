@@ -0,0 +1,53 @@
+package server
+
+import (
+	"io"
+	"net/http"
+)
+
+// countingWriter 记录响应状态码和实际写出字节数的ResponseWriter
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader 记录状态码
+func (cw *countingWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write 记录写出的字节数
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom 交由底层ResponseWriter的io.ReaderFrom处理，使文件下载仍能使用sendfile
+func (cw *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	var n int64
+	var err error
+	if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(cw.ResponseWriter, r)
+	}
+	cw.bytes += n
+	return n, err
+}
+
+// Unwrap 返回底层的ResponseWriter，供http.ResponseController使用
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readerFromRecorder 记录ReadFrom是否被调用的ResponseWriter
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestCountingWriterReadFrom(t *testing.T) {
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	// 两层包装与instrument和handleDownload的组合一致
+	outer := &countingWriter{ResponseWriter: rec}
+	inner := &countingWriter{ResponseWriter: outer}
+
+	// http.ServeContent通过io.CopyN写出文件内容，LimitReader没有WriteTo，会调用ReadFrom
+	n, err := io.CopyN(inner, strings.NewReader("hello world"), 11)
+	if err != nil || n != 11 {
+		t.Fatalf("io.Copy = %d, %v", n, err)
+	}
+	if !rec.readFrom {
+		t.Error("未使用底层ResponseWriter的ReadFrom")
+	}
+	if inner.bytes != 11 || outer.bytes != 11 {
+		t.Errorf("计数 = %d, %d, want 11", inner.bytes, outer.bytes)
+	}
+	if inner.status != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Errorf("响应 = %d %q", inner.status, rec.Body.String())
+	}
+}
+
+func TestCountingWriterReadFromFallback(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cw := &countingWriter{ResponseWriter: rec}
+
+	n, err := cw.ReadFrom(strings.NewReader("hello"))
+	if err != nil || n != 5 || cw.bytes != 5 || rec.Body.String() != "hello" {
+		t.Errorf("ReadFrom = %d, %v, bytes %d, body %q", n, err, cw.bytes, rec.Body.String())
+	}
+}
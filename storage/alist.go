@@ -2,15 +2,19 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
 )
 
 // AListStorage AList存储实现
@@ -21,6 +25,7 @@ type AListStorage struct {
 	password string
 	path     string
 	token    string
+	logger   *logger.Logger
 }
 
 // AListLoginRequest AList登录请求
@@ -88,7 +93,7 @@ type AListFsInfoResponse struct {
 }
 
 // NewAListStorage 创建新的AList存储实例
-func NewAListStorage(cfg config.AListConfig) *AListStorage {
+func NewAListStorage(cfg config.AListConfig, logger *logger.Logger) *AListStorage {
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
@@ -109,21 +114,22 @@ func NewAListStorage(cfg config.AListConfig) *AListStorage {
 		password: cfg.Password,
 		path:     path,
 		token:    cfg.Token,
+		logger:   logger,
 	}
 }
 
 // Init 初始化AList存储
-func (a *AListStorage) Init() error {
+func (a *AListStorage) Init(ctx context.Context) error {
 	// 如果没有提供token，则尝试登录获取token
 	if a.token == "" {
-		err := a.login()
+		err := a.login(ctx)
 		if err != nil {
 			return fmt.Errorf("AList登录失败: %w", err)
 		}
 	}
 
 	// 确保基础目录存在
-	err := a.makeDir(a.path)
+	err := a.makeDir(ctx, a.path)
 	if err != nil {
 		return fmt.Errorf("无法创建基础目录 %s: %w", a.path, err)
 	}
@@ -132,7 +138,7 @@ func (a *AListStorage) Init() error {
 }
 
 // login 登录AList获取token
-func (a *AListStorage) login() error {
+func (a *AListStorage) login(ctx context.Context) error {
 	loginReq := AListLoginRequest{
 		Username: a.username,
 		Password: a.password,
@@ -143,7 +149,7 @@ func (a *AListStorage) login() error {
 		return fmt.Errorf("无法序列化登录请求: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", a.endpoint+"/api/auth/login", bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint+"/api/auth/login", bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("无法创建登录请求: %w", err)
 	}
@@ -180,7 +186,7 @@ func (a *AListStorage) login() error {
 }
 
 // makeDir 创建目录
-func (a *AListStorage) makeDir(path string) error {
+func (a *AListStorage) makeDir(ctx context.Context, path string) error {
 	makeDirReq := AListMakeDirRequest{
 		Path: path,
 	}
@@ -190,7 +196,7 @@ func (a *AListStorage) makeDir(path string) error {
 		return fmt.Errorf("无法序列化创建目录请求: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", a.endpoint+"/api/fs/mkdir", bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint+"/api/fs/mkdir", bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("无法创建目录请求: %w", err)
 	}
@@ -219,9 +225,9 @@ func (a *AListStorage) makeDir(path string) error {
 }
 
 // Check 检查AList存储是否可用
-func (a *AListStorage) Check() (bool, error) {
+func (a *AListStorage) Check(ctx context.Context) (bool, error) {
 	// 尝试列出基础目录内容
-	_, err := a.listDir(a.path)
+	_, err := a.listDir(ctx, a.path)
 	if err != nil {
 		return false, err
 	}
@@ -230,7 +236,7 @@ func (a *AListStorage) Check() (bool, error) {
 }
 
 // Get 获取文件，返回重定向URL而不是实际文件内容
-func (a *AListStorage) Get(hash string) (io.ReadCloser, error) {
+func (a *AListStorage) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
 	// 构建文件在AList服务器上的路径
 	filePath := filepath.Join(a.path, hash[:2], hash)
 
@@ -241,17 +247,49 @@ func (a *AListStorage) Get(hash string) (io.ReadCloser, error) {
 	return &redirectReadCloser{redirectURL: fullURL}, nil
 }
 
+// MeasureURL 确保测速文件已上传，并返回其可访问的URL
+func (a *AListStorage) MeasureURL(ctx context.Context, size int) (string, error) {
+	filePath := filepath.ToSlash(filepath.Join(a.path, measurePath(size)))
+
+	// 已存在且大小一致的测速文件可以直接复用
+	files, err := a.listDir(ctx, filepath.ToSlash(filepath.Dir(filePath)))
+	found := false
+	if err == nil {
+		name := filepath.Base(filePath)
+		for _, file := range files {
+			if file.Name == name && !file.IsDir && file.Size == MeasureSize(size) {
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		dir := filepath.ToSlash(filepath.Dir(filePath))
+		if err := a.makeDir(ctx, dir); err != nil {
+			return "", fmt.Errorf("无法创建目录 %s: %w", dir, err)
+		}
+
+		err := a.uploadStream(ctx, filePath, NewMeasureReader(size), MeasureSize(size))
+		if err != nil {
+			return "", fmt.Errorf("无法上传测速文件 %s: %w", filePath, err)
+		}
+	}
+
+	return a.endpoint + "/d" + filePath, nil
+}
+
 // Put 存储文件
-func (a *AListStorage) Put(hash string, data io.Reader) error {
+func (a *AListStorage) Put(ctx context.Context, hash string, data io.Reader) error {
 	// 创建目录
 	dir := filepath.Join(a.path, hash[:2])
-	err := a.makeDir(dir)
+	err := a.makeDir(ctx, dir)
 	if err != nil {
 		return fmt.Errorf("无法创建目录 %s: %w", dir, err)
 	}
 
 	// 读取数据
-	fileData, err := io.ReadAll(data)
+	fileData, err := io.ReadAll(contextReader(ctx, data))
 	if err != nil {
 		return fmt.Errorf("无法读取文件数据: %w", err)
 	}
@@ -260,7 +298,7 @@ func (a *AListStorage) Put(hash string, data io.Reader) error {
 	filePath := filepath.Join(dir, hash)
 
 	// 上传文件
-	err = a.uploadFile(filePath, fileData)
+	err = a.uploadFile(ctx, filePath, fileData)
 	if err != nil {
 		return fmt.Errorf("无法上传文件 %s: %w", filePath, err)
 	}
@@ -269,7 +307,12 @@ func (a *AListStorage) Put(hash string, data io.Reader) error {
 }
 
 // uploadFile 上传文件到AList
-func (a *AListStorage) uploadFile(path string, data []byte) error {
+func (a *AListStorage) uploadFile(ctx context.Context, path string, data []byte) error {
+	return a.uploadStream(ctx, path, bytes.NewReader(data), int64(len(data)))
+}
+
+// uploadStream 以流的方式上传已知大小的数据到AList
+func (a *AListStorage) uploadStream(ctx context.Context, path string, data io.Reader, size int64) error {
 	// AList的上传API需要使用multipart/form-data格式
 	// 这里我们使用简单的PUT方法上传文件
 
@@ -277,10 +320,11 @@ func (a *AListStorage) uploadFile(path string, data []byte) error {
 	url := a.endpoint + "/api/fs/put"
 
 	// 创建请求
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, data)
 	if err != nil {
 		return fmt.Errorf("无法创建上传请求: %w", err)
 	}
+	req.ContentLength = size
 
 	// 设置头部
 	req.Header.Set("Authorization", a.token)
@@ -309,15 +353,15 @@ func (a *AListStorage) uploadFile(path string, data []byte) error {
 }
 
 // Delete 删除文件
-func (a *AListStorage) Delete(hash string) error {
+func (a *AListStorage) Delete(ctx context.Context, hash string) error {
 	// 构建文件路径
 	filePath := filepath.Join(a.path, hash[:2], hash)
 
 	// 删除文件
-	err := a.deleteFile(filePath)
+	err := a.deleteFile(ctx, filePath)
 	if err != nil {
 		// 如果是文件不存在错误，我们不返回错误
-		if strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, os.ErrNotExist) || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "not found") {
 			return nil
 		}
 		return fmt.Errorf("无法删除文件 %s: %w", filePath, err)
@@ -327,7 +371,7 @@ func (a *AListStorage) Delete(hash string) error {
 }
 
 // deleteFile 从AList删除文件
-func (a *AListStorage) deleteFile(path string) error {
+func (a *AListStorage) deleteFile(ctx context.Context, path string) error {
 	deleteReq := AListDeleteRequest{
 		Path: path,
 	}
@@ -337,7 +381,7 @@ func (a *AListStorage) deleteFile(path string) error {
 		return fmt.Errorf("无法序列化删除请求: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", a.endpoint+"/api/fs/remove", bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint+"/api/fs/remove", bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("无法创建删除请求: %w", err)
 	}
@@ -366,12 +410,12 @@ func (a *AListStorage) deleteFile(path string) error {
 }
 
 // Exists 检查文件是否存在
-func (a *AListStorage) Exists(hash string) (bool, error) {
+func (a *AListStorage) Exists(ctx context.Context, hash string) (bool, error) {
 	// 构建文件路径
 	filePath := filepath.Join(a.path, hash[:2], hash)
 
 	// 检查文件是否存在
-	exists, err := a.fileExists(filePath)
+	exists, err := a.fileExists(ctx, filePath)
 	if err != nil {
 		return false, fmt.Errorf("检查文件存在性失败 %s: %w", filePath, err)
 	}
@@ -380,13 +424,17 @@ func (a *AListStorage) Exists(hash string) (bool, error) {
 }
 
 // fileExists 检查AList中的文件是否存在
-func (a *AListStorage) fileExists(path string) (bool, error) {
+func (a *AListStorage) fileExists(ctx context.Context, path string) (bool, error) {
 	// 使用list接口检查文件是否存在
 	dir := filepath.Dir(path)
 	filename := filepath.Base(path)
 
-	files, err := a.listDir(dir)
+	files, err := a.listDir(ctx, dir)
 	if err != nil {
+		// 分片目录尚不存在时文件自然也不存在
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
 		return false, err
 	}
 
@@ -400,12 +448,12 @@ func (a *AListStorage) fileExists(path string) (bool, error) {
 }
 
 // listDir 列出AList目录中的文件
-func (a *AListStorage) listDir(path string) ([]AListFileInfo, error) {
+func (a *AListStorage) listDir(ctx context.Context, path string) ([]AListFileInfo, error) {
 	// 构建请求URL
 	url := fmt.Sprintf("%s/api/fs/list?path=%s", a.endpoint, path)
 
 	// 创建请求
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("无法创建列表请求: %w", err)
 	}
@@ -442,6 +490,10 @@ func (a *AListStorage) listDir(path string) ([]AListFileInfo, error) {
 	}
 
 	if listResp.Code != 200 {
+		// 目录不存在时AList返回"object not found"
+		if strings.Contains(listResp.Message, "not found") {
+			return nil, fmt.Errorf("列表请求失败: %w: %s", os.ErrNotExist, listResp.Message)
+		}
 		return nil, fmt.Errorf("列表请求失败: %s", listResp.Message)
 	}
 
@@ -461,19 +513,19 @@ func (a *AListStorage) listDir(path string) ([]AListFileInfo, error) {
 }
 
 // WriteFile 写入文件
-func (a *AListStorage) WriteFile(filePath string, content []byte, fileInfo *FileInfo) error {
+func (a *AListStorage) WriteFile(ctx context.Context, filePath string, content []byte, fileInfo *FileInfo) error {
 	// 构建完整路径
 	fullPath := filepath.Join(a.path, filePath)
 
 	// 确保目录存在
 	dir := filepath.Dir(fullPath)
-	err := a.makeDir(dir)
+	err := a.makeDir(ctx, dir)
 	if err != nil {
 		return fmt.Errorf("无法创建目录 %s: %w", dir, err)
 	}
 
 	// 上传文件
-	err = a.uploadFile(fullPath, content)
+	err = a.uploadFile(ctx, fullPath, content)
 	if err != nil {
 		return fmt.Errorf("无法写入文件 %s: %w", fullPath, err)
 	}
@@ -482,11 +534,11 @@ func (a *AListStorage) WriteFile(filePath string, content []byte, fileInfo *File
 }
 
 // ListFiles 列出所有已存在的文件
-func (a *AListStorage) ListFiles() ([]*FileInfo, error) {
+func (a *AListStorage) ListFiles(ctx context.Context) ([]*FileInfo, error) {
 	var files []*FileInfo
 
 	// 遍历存储目录，获取所有已存在的文件
-	err := a.walkDir(a.path, "", &files)
+	err := a.walkDir(ctx, a.path, "", &files)
 	if err != nil {
 		return nil, fmt.Errorf("遍历目录失败: %w", err)
 	}
@@ -495,13 +547,19 @@ func (a *AListStorage) ListFiles() ([]*FileInfo, error) {
 }
 
 // walkDir 递归遍历目录
-func (a *AListStorage) walkDir(basePath, relPath string, files *[]*FileInfo) error {
+func (a *AListStorage) walkDir(ctx context.Context, basePath, relPath string, files *[]*FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	currentPath := filepath.Join(basePath, relPath)
-	entries, err := a.listDir(currentPath)
+	entries, err := a.listDir(ctx, currentPath)
 	if err != nil {
 		// 忽略无法访问的目录
 		// 但记录警告信息以便调试
-		fmt.Printf("[WARN] 无法访问目录 %s: %v\n", currentPath, err)
+		if !errors.Is(err, os.ErrNotExist) {
+			a.logger.Warn("无法访问目录 %s: %v", currentPath, err)
+		}
 		return nil
 	}
 
@@ -510,7 +568,7 @@ func (a *AListStorage) walkDir(basePath, relPath string, files *[]*FileInfo) err
 
 		if entry.IsDir {
 			// 递归处理子目录
-			err := a.walkDir(basePath, entryRelPath, files)
+			err := a.walkDir(ctx, basePath, entryRelPath, files)
 			if err != nil {
 				return err
 			}
@@ -539,9 +597,9 @@ func (a *AListStorage) walkDir(basePath, relPath string, files *[]*FileInfo) err
 }
 
 // GetMissingFiles 获取缺失的文件列表
-func (a *AListStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error) {
+func (a *AListStorage) GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error) {
 	// 获取所有已存在的文件
-	existingFiles, err := a.ListFiles()
+	existingFiles, err := a.ListFiles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("无法列出已存在的文件: %w", err)
 	}
@@ -563,105 +621,7 @@ func (a *AListStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error) {
 	return missing, nil
 }
 
-// GC 垃圾回收
-func (a *AListStorage) GC(files []*FileInfo) error {
-	// 获取所有已存在的文件
-	existingFiles, err := a.ListFiles()
-	if err != nil {
-		return fmt.Errorf("无法列出已存在的文件: %w", err)
-	}
-
-	// 创建一个map来存储需要保留的文件
-	keepMap := make(map[string]bool)
-	for _, file := range files {
-		keepMap[file.Hash] = true
-	}
-
-	// 删除不需要的文件
-	var deletedCount int
-	for _, file := range existingFiles {
-		if !keepMap[file.Hash] {
-			err := a.Delete(file.Hash)
-			if err != nil {
-				// 记录错误但继续删除其他文件
-				fmt.Printf("无法删除文件 %s: %v\n", file.Hash, err)
-				continue
-			}
-			deletedCount++
-		}
-	}
-
-	fmt.Printf("垃圾回收完成，删除了 %d 个文件\n", deletedCount)
-	return nil
-}
-
-// GetLastModified 获取存储中所有文件的最新修改时间（Unix时间戳）
-func (a *AListStorage) GetLastModified() (int64, error) {
-	var lastModified int64
-
-	// 遍历所有文件查找最新修改时间
-	err := a.walkDirModified(a.path, "", &lastModified)
-	if err != nil {
-		return 0, fmt.Errorf("遍历目录失败: %w", err)
-	}
-
-	return lastModified, nil
-}
-
-// walkDirModified 递归遍历目录查找最新修改时间
-func (a *AListStorage) walkDirModified(basePath, relPath string, lastModified *int64) error {
-	currentPath := filepath.Join(basePath, relPath)
-	entries, err := a.listDir(currentPath)
-	if err != nil {
-		// 忽略无法访问的目录
-		return nil
-	}
-
-	for _, entry := range entries {
-		entryRelPath := filepath.Join(relPath, entry.Name)
-
-		if entry.IsDir {
-			// 递归处理子目录
-			err := a.walkDirModified(basePath, entryRelPath, lastModified)
-			if err != nil {
-				return err
-			}
-		} else {
-			// 检查文件修改时间
-			modifiedTime := a.parseModifiedTime(entry.Modified)
-			if modifiedTime > *lastModified {
-				*lastModified = modifiedTime
-			}
-		}
-	}
-
-	return nil
-}
-
-// parseModifiedTime 解析修改时间，处理不同类型的modified字段
-func (a *AListStorage) parseModifiedTime(modified interface{}) int64 {
-	switch v := modified.(type) {
-	case string:
-		// 尝试解析时间戳字符串
-		var t int64
-		if _, err := fmt.Sscanf(v, "%d", &t); err == nil {
-			return t
-		}
-		return 0
-	case int64:
-		return v
-	case float64:
-		return int64(v)
-	case int:
-		return int64(v)
-	case int32:
-		return int64(v)
-	case json.Number:
-		if i, err := v.Int64(); err == nil {
-			return i
-		}
-		return 0
-	default:
-		return 0
-	}
+// GC 垃圾回收，删除不在files列表中的文件
+func (a *AListStorage) GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	return collectGarbage(ctx, a, files, opts)
 }
@@ -1,38 +1,108 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
 )
 
 // FileStorage 文件存储实现
 type FileStorage struct {
-	path string
+	path   string
+	logger *logger.Logger
 }
 
 // NewFileStorage 创建新的文件存储实例
-func NewFileStorage(path string) *FileStorage {
+func NewFileStorage(path string, logger *logger.Logger) *FileStorage {
 	return &FileStorage{
-		path: path,
+		path:   path,
+		logger: logger,
 	}
 }
 
+// tempFilePrefix 写入过程中临时文件的文件名前缀
+const tempFilePrefix = ".tmp-"
+
 // Init 初始化文件存储
-func (fs *FileStorage) Init() error {
+func (fs *FileStorage) Init(ctx context.Context) error {
 	// 创建存储目录
 	err := os.MkdirAll(fs.path, 0755)
 	if err != nil {
 		return fmt.Errorf("无法创建存储目录 %s: %w", fs.path, err)
 	}
+
+	// 清理上次异常退出时遗留的临时文件
+	err = fs.cleanTempFiles()
+	if err != nil {
+		return fmt.Errorf("无法清理临时文件: %w", err)
+	}
+	return nil
+}
+
+// cleanTempFiles 删除存储目录中遗留的临时文件
+func (fs *FileStorage) cleanTempFiles() error {
+	return filepath.Walk(fs.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() && strings.HasPrefix(info.Name(), tempFilePrefix) {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeAtomic 将数据写入同目录下的临时文件，同步到磁盘后再重命名到目标路径，
+// 写入中断时目标路径上不会出现不完整的文件
+func writeAtomic(path string, data io.Reader) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, tempFilePrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("无法创建临时文件: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// 任何一步失败都删除临时文件
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		return fmt.Errorf("无法写入数据: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("无法同步数据到磁盘: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("无法设置文件权限: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("无法关闭临时文件: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("无法重命名临时文件: %w", err)
+	}
+
+	success = true
 	return nil
 }
 
 // Check 检查文件存储是否可用
-func (fs *FileStorage) Check() (bool, error) {
+func (fs *FileStorage) Check(ctx context.Context) (bool, error) {
 	// 检查目录是否存在且可写
 	_, err := os.Stat(fs.path)
 	if os.IsNotExist(err) {
@@ -57,12 +127,12 @@ func (fs *FileStorage) Check() (bool, error) {
 }
 
 // Get 获取文件
-func (fs *FileStorage) Get(hash string) (io.ReadCloser, error) {
+func (fs *FileStorage) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
 	path := filepath.Join(fs.path, hash[:2], hash)
 	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("文件不存在: %s", hash)
+			return nil, fmt.Errorf("文件不存在 %s: %w", hash, os.ErrNotExist)
 		}
 		return nil, fmt.Errorf("无法打开文件 %s: %w", path, err)
 	}
@@ -70,7 +140,7 @@ func (fs *FileStorage) Get(hash string) (io.ReadCloser, error) {
 }
 
 // Put 存储文件
-func (fs *FileStorage) Put(hash string, data io.Reader) error {
+func (fs *FileStorage) Put(ctx context.Context, hash string, data io.Reader) error {
 	// 创建目录
 	dir := filepath.Join(fs.path, hash[:2])
 	err := os.MkdirAll(dir, 0755)
@@ -78,16 +148,9 @@ func (fs *FileStorage) Put(hash string, data io.Reader) error {
 		return fmt.Errorf("无法创建目录 %s: %w", dir, err)
 	}
 
-	// 创建文件
+	// 先写入临时文件，完成后再原子地重命名
 	path := filepath.Join(dir, hash)
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("无法创建文件 %s: %w", path, err)
-	}
-	defer file.Close()
-
-	// 写入数据
-	_, err = io.Copy(file, data)
+	err = writeAtomic(path, contextReader(ctx, data))
 	if err != nil {
 		return fmt.Errorf("无法写入文件 %s: %w", path, err)
 	}
@@ -96,7 +159,7 @@ func (fs *FileStorage) Put(hash string, data io.Reader) error {
 }
 
 // Delete 删除文件
-func (fs *FileStorage) Delete(hash string) error {
+func (fs *FileStorage) Delete(ctx context.Context, hash string) error {
 	path := filepath.Join(fs.path, hash[:2], hash)
 	err := os.Remove(path)
 	if err != nil {
@@ -106,7 +169,7 @@ func (fs *FileStorage) Delete(hash string) error {
 }
 
 // Exists 检查文件是否存在
-func (fs *FileStorage) Exists(hash string) (bool, error) {
+func (fs *FileStorage) Exists(ctx context.Context, hash string) (bool, error) {
 	path := filepath.Join(fs.path, hash[:2], hash)
 	_, err := os.Stat(path)
 	if err != nil {
@@ -119,7 +182,7 @@ func (fs *FileStorage) Exists(hash string) (bool, error) {
 }
 
 // WriteFile 写入文件
-func (fs *FileStorage) WriteFile(filePath string, content []byte, fileInfo *FileInfo) error {
+func (fs *FileStorage) WriteFile(ctx context.Context, filePath string, content []byte, fileInfo *FileInfo) error {
 	fullPath := filepath.Join(fs.path, filePath)
 
 	// 确保目录存在
@@ -130,7 +193,7 @@ func (fs *FileStorage) WriteFile(filePath string, content []byte, fileInfo *File
 	}
 
 	// 写入文件
-	err = os.WriteFile(fullPath, content, 0644)
+	err = writeAtomic(fullPath, bytes.NewReader(content))
 	if err != nil {
 		return fmt.Errorf("无法写入文件 %s: %w", filePath, err)
 	}
@@ -139,18 +202,22 @@ func (fs *FileStorage) WriteFile(filePath string, content []byte, fileInfo *File
 }
 
 // ListFiles 列出所有已存在的文件
-func (fs *FileStorage) ListFiles() ([]*FileInfo, error) {
+func (fs *FileStorage) ListFiles(ctx context.Context) ([]*FileInfo, error) {
 	var files []*FileInfo
 
 	// 遍历存储目录，获取所有已存在的文件
 	err := filepath.Walk(fs.path, func(path string, info os.FileInfo, err error) error {
+		// 目录较大时遍历耗时较长，需及时响应取消
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			// 忽略无法访问的目录或文件
 			return nil
 		}
 
-		// 只处理文件，忽略目录
-		if info.IsDir() {
+		// 只处理文件，忽略目录和正在写入的临时文件
+		if info.IsDir() || strings.HasPrefix(info.Name(), tempFilePrefix) {
 			return nil
 		}
 
@@ -162,8 +229,11 @@ func (fs *FileStorage) ListFiles() ([]*FileInfo, error) {
 
 		// 验证是否符合我们的存储结构（两级目录结构）
 		if len(relPath) >= 3 && relPath[2] == filepath.Separator {
-			// 提取文件名（hash）
-			hash := relPath[0:2] + relPath[3:]
+			// 提取文件名（hash），文件名本身就是完整的hash
+			hash := relPath[3:]
+			if strings.ContainsRune(hash, filepath.Separator) || !strings.HasPrefix(hash, relPath[0:2]) {
+				return nil
+			}
 			fileInfo := &FileInfo{
 				Hash: hash,
 				Size: info.Size(),
@@ -186,14 +256,14 @@ func (fs *FileStorage) ListFiles() ([]*FileInfo, error) {
 func (fs *FileStorage) calculateFileChecksum(path string) string {
 	file, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("警告：无法打开文件 %s: %v\n", path, err)
+		fs.logger.Warn("无法打开文件 %s: %v", path, err)
 		return ""
 	}
 	defer file.Close()
 
 	hash := sha256.New()
 	if _, err := io.Copy(hash, file); err != nil {
-		fmt.Printf("警告：无法计算文件 %s 的校验和: %v\n", path, err)
+		fs.logger.Warn("无法计算文件 %s 的校验和: %v", path, err)
 		return ""
 	}
 
@@ -201,9 +271,9 @@ func (fs *FileStorage) calculateFileChecksum(path string) string {
 }
 
 // GetMissingFiles 获取缺失的文件列表
-func (fs *FileStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error) {
+func (fs *FileStorage) GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error) {
 	// 获取所有已存在的文件
-	existingFiles, err := fs.ListFiles()
+	existingFiles, err := fs.ListFiles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("无法列出已存在的文件: %w", err)
 	}
@@ -225,60 +295,7 @@ func (fs *FileStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error) {
 	return missing, nil
 }
 
-// GC 垃圾回收
-func (fs *FileStorage) GC(files []*FileInfo) error {
-	// 获取所有已存在的文件
-	existingFiles, err := fs.ListFiles()
-	if err != nil {
-		return fmt.Errorf("无法列出已存在的文件: %w", err)
-	}
-
-	// 创建一个map来存储需要保留的文件
-	keepMap := make(map[string]bool)
-	for _, file := range files {
-		keepMap[file.Hash] = true
-	}
-
-	// 删除不需要的文件
-	var deletedCount int
-	for _, file := range existingFiles {
-		if !keepMap[file.Hash] {
-			err := fs.Delete(file.Hash)
-			if err != nil {
-				// 记录错误但继续删除其他文件
-				fmt.Printf("无法删除文件 %s: %v\n", file.Hash, err)
-				continue
-			}
-			deletedCount++
-		}
-	}
-
-	fmt.Printf("垃圾回收完成，删除了 %d 个文件\n", deletedCount)
-	return nil
-}
-
-// GetLastModified 获取存储中所有文件的最新修改时间（Unix时间戳）
-func (fs *FileStorage) GetLastModified() (int64, error) {
-	var lastModified int64
-
-	err := filepath.Walk(fs.path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-
-		if !info.IsDir() {
-			modTime := info.ModTime().Unix()
-			if modTime > lastModified {
-				lastModified = modTime
-			}
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return 0, fmt.Errorf("遍历目录失败: %w", err)
-	}
-
-	return lastModified, nil
+// GC 垃圾回收，删除不在files列表中的文件
+func (fs *FileStorage) GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	return collectGarbage(ctx, fs, files, opts)
 }
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+const testHash = "0a4d55a8d778e5022fab701977c5d840bbc486d0"
+
+// failingReader 读取limit字节后返回错误，模拟中途断开的下载
+type failingReader struct {
+	data  []byte
+	limit int
+}
+
+var errInterrupted = errors.New("下载中断")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.limit == 0 {
+		return 0, errInterrupted
+	}
+	n := copy(p[:min(len(p), r.limit)], r.data)
+	r.data = r.data[n:]
+	r.limit -= n
+	return n, nil
+}
+
+func newTestFileStorage(t *testing.T) *FileStorage {
+	t.Helper()
+	fs := NewFileStorage(t.TempDir(), logger.New(false))
+	if err := fs.Init(context.Background()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return fs
+}
+
+// shardEntries 返回hash所在分片目录中的所有文件名
+func shardEntries(t *testing.T, fs *FileStorage, hash string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(fs.path, hash[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileStoragePut(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx := context.Background()
+
+	if err := fs.Put(ctx, testHash, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(fs.path, testHash[:2], testHash))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("内容 = %q, want %q", data, "hello")
+	}
+	if names := shardEntries(t, fs, testHash); len(names) != 1 {
+		t.Errorf("分片目录中有多余的文件: %v", names)
+	}
+}
+
+func TestFileStoragePutInterrupted(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx := context.Background()
+
+	reader := &failingReader{data: bytes.Repeat([]byte("x"), 1<<20), limit: 64 << 10}
+	err := fs.Put(ctx, testHash, reader)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("Put 错误 = %v, want %v", err, errInterrupted)
+	}
+
+	if exists, err := fs.Exists(ctx, testHash); err != nil || exists {
+		t.Errorf("Exists = %v, %v, want false", exists, err)
+	}
+	if names := shardEntries(t, fs, testHash); len(names) != 0 {
+		t.Errorf("写入中断后遗留了文件: %v", names)
+	}
+}
+
+func TestFileStoragePutReplacesOnlyOnSuccess(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx := context.Background()
+
+	if err := fs.Put(ctx, testHash, strings.NewReader("old")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reader := &failingReader{data: []byte("new content"), limit: 3}
+	if err := fs.Put(ctx, testHash, reader); err == nil {
+		t.Fatal("Put 应当失败")
+	}
+
+	data, err := os.ReadFile(filepath.Join(fs.path, testHash[:2], testHash))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "old" {
+		t.Errorf("写入失败后原文件被修改: %q", data)
+	}
+}
+
+func TestFileStoragePutCancelled(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := fs.Put(ctx, testHash, strings.NewReader("hello"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Put 错误 = %v, want %v", err, context.Canceled)
+	}
+	if names := shardEntries(t, fs, testHash); len(names) != 0 {
+		t.Errorf("取消后遗留了文件: %v", names)
+	}
+}
+
+func TestFileStorageInitCleansTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, testHash[:2])
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := filepath.Join(shard, tempFilePrefix+testHash+"-123456")
+	kept := filepath.Join(shard, testHash)
+	for _, path := range []string{stale, kept} {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := NewFileStorage(dir, logger.New(false))
+	if err := fs.Init(context.Background()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Init 后临时文件仍然存在: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("Init 删除了正常文件: %v", err)
+	}
+}
+
+func TestFileStorageListFilesSkipsTempFiles(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx := context.Background()
+
+	if err := fs.Put(ctx, testHash, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	tmp := filepath.Join(fs.path, testHash[:2], tempFilePrefix+testHash+"-1")
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := fs.ListFiles(ctx)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Hash != testHash {
+		t.Errorf("ListFiles = %v, want 仅 %s", files, testHash)
+	}
+}
+
+func TestFileStorageGetMissing(t *testing.T) {
+	fs := newTestFileStorage(t)
+	store := instrument(fs, "file-test")
+
+	// 计数器是全局的，只比较本次调用前后的差值
+	notFound := storageOperations.WithLabelValues("file-test", "get", "not_found")
+	failed := storageOperations.WithLabelValues("file-test", "get", "error")
+	notFoundBefore, failedBefore := testutil.ToFloat64(notFound), testutil.ToFloat64(failed)
+
+	_, err := store.Get(context.Background(), testHash)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get 错误 = %v, want os.ErrNotExist", err)
+	}
+
+	// 文件不存在不应计为存储故障
+	if n := testutil.ToFloat64(notFound) - notFoundBefore; n != 1 {
+		t.Errorf("not_found 计数增加 %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(failed) - failedBefore; n != 0 {
+		t.Errorf("error 计数增加 %v, want 0", n)
+	}
+}
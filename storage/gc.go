@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrGCThresholdExceeded 待删除的文件占比超过安全阈值
+var ErrGCThresholdExceeded = errors.New("待删除文件占比超过安全阈值")
+
+// GCOptions 垃圾回收选项
+type GCOptions struct {
+	// DryRun 只统计需要删除的文件，不实际删除
+	DryRun bool
+	// MaxDeleteRatio 单次最多允许删除的文件占缓存的比例，大于等于1表示不限制
+	MaxDeleteRatio float64
+}
+
+// GCResult 垃圾回收结果
+type GCResult struct {
+	// Scanned 存储中已存在的文件数
+	Scanned int
+	// Deleted 已删除（dry-run时为将要删除）的文件数
+	Deleted int
+	// Bytes 已回收（dry-run时为将要回收）的字节数
+	Bytes int64
+	// Failed 删除失败的文件数
+	Failed int
+	// DryRun 是否为dry-run
+	DryRun bool
+}
+
+// collectGarbage 删除存储中不在files列表内的文件，供各存储实现的GC复用
+func collectGarbage(ctx context.Context, s Storage, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	// 获取所有已存在的文件
+	existingFiles, err := s.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("无法列出已存在的文件: %w", err)
+	}
+
+	// 创建一个map来存储需要保留的文件
+	keepMap := make(map[string]bool, len(files))
+	for _, file := range files {
+		keepMap[file.Hash] = true
+	}
+
+	// 找出需要删除的文件
+	var garbage []*FileInfo
+	for _, file := range existingFiles {
+		if !keepMap[file.Hash] {
+			garbage = append(garbage, file)
+		}
+	}
+
+	result := &GCResult{
+		Scanned: len(existingFiles),
+		DryRun:  opts.DryRun,
+	}
+
+	// 待删除的文件过多时很可能是文件列表异常，拒绝执行
+	if opts.MaxDeleteRatio < 1 && len(existingFiles) > 0 {
+		ratio := float64(len(garbage)) / float64(len(existingFiles))
+		if ratio > opts.MaxDeleteRatio {
+			return result, fmt.Errorf("%w: 需删除 %d/%d 个文件 (%.1f%%), 阈值 %.1f%%",
+				ErrGCThresholdExceeded, len(garbage), len(existingFiles), ratio*100, opts.MaxDeleteRatio*100)
+		}
+	}
+
+	for _, file := range garbage {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !opts.DryRun {
+			if err := s.Delete(ctx, file.Hash); err != nil {
+				// 记录失败但继续删除其他文件
+				result.Failed++
+				continue
+			}
+		}
+		result.Deleted++
+		result.Bytes += file.Size
+	}
+
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// gcHashes 测试使用的文件哈希，文件内容长度依次为1到4字节
+var gcHashes = []string{
+	"1111111111111111111111111111111111111111",
+	"2222222222222222222222222222222222222222",
+	"3333333333333333333333333333333333333333",
+	"4444444444444444444444444444444444444444",
+}
+
+func TestFileStorageGC(t *testing.T) {
+	tests := []struct {
+		name        string
+		keep        int
+		opts        GCOptions
+		wantErr     error
+		wantDeleted int
+		wantBytes   int64
+		wantRemain  int
+	}{
+		{"删除未列出的文件", 3, GCOptions{MaxDeleteRatio: 0.5}, nil, 1, 4, 3},
+		{"恰好达到阈值", 2, GCOptions{MaxDeleteRatio: 0.5}, nil, 2, 7, 2},
+		{"超过阈值时拒绝删除", 1, GCOptions{MaxDeleteRatio: 0.5}, ErrGCThresholdExceeded, 0, 0, 4},
+		{"文件列表为空时拒绝删除", 0, GCOptions{MaxDeleteRatio: 0.5}, ErrGCThresholdExceeded, 0, 0, 4},
+		{"不限制比例", 0, GCOptions{MaxDeleteRatio: 1}, nil, 4, 10, 0},
+		{"dry-run不删除文件", 2, GCOptions{DryRun: true, MaxDeleteRatio: 1}, nil, 2, 7, 4},
+		{"dry-run同样受阈值限制", 1, GCOptions{DryRun: true, MaxDeleteRatio: 0.5}, ErrGCThresholdExceeded, 0, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newTestFileStorage(t)
+			ctx := context.Background()
+			var files []*FileInfo
+			for i, hash := range gcHashes {
+				if err := fs.Put(ctx, hash, strings.NewReader(strings.Repeat("x", i+1))); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+				files = append(files, &FileInfo{Hash: hash, Size: int64(i + 1)})
+			}
+
+			result, err := fs.GC(ctx, files[:tt.keep], tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GC 错误 = %v, want %v", err, tt.wantErr)
+			}
+			if result == nil {
+				t.Fatal("GC 结果为空")
+			}
+			if result.Scanned != len(gcHashes) || result.Deleted != tt.wantDeleted ||
+				result.Bytes != tt.wantBytes || result.DryRun != tt.opts.DryRun {
+				t.Errorf("GC 结果 = %+v", result)
+			}
+
+			remaining, err := fs.ListFiles(ctx)
+			if err != nil {
+				t.Fatalf("ListFiles: %v", err)
+			}
+			if len(remaining) != tt.wantRemain {
+				t.Errorf("剩余 %d 个文件, want %d", len(remaining), tt.wantRemain)
+			}
+			// 列表中的文件始终保留
+			for _, file := range files[:tt.keep] {
+				if !containsHash(remaining, file.Hash) {
+					t.Errorf("列表中的文件 %s 被删除", file.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStorageGCEmpty(t *testing.T) {
+	fs := newTestFileStorage(t)
+
+	// 存储为空时不会因比例计算而拒绝
+	result, err := fs.GC(context.Background(), nil, GCOptions{MaxDeleteRatio: 0.1})
+	if err != nil || result.Scanned != 0 || result.Deleted != 0 {
+		t.Errorf("GC = %+v, %v", result, err)
+	}
+}
+
+func TestFileStorageGCRespectsContext(t *testing.T) {
+	fs := newTestFileStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := fs.Put(ctx, gcHashes[0], strings.NewReader("x")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	cancel()
+
+	if _, err := fs.GC(ctx, nil, GCOptions{MaxDeleteRatio: 1}); err == nil {
+		t.Error("ctx取消后 GC 应当返回错误")
+	}
+	if exists, _ := fs.Exists(context.Background(), gcHashes[0]); !exists {
+		t.Error("ctx取消后文件仍被删除")
+	}
+}
+
+// containsHash 返回files中是否有哈希为hash的文件
+func containsHash(files []*FileInfo, hash string) bool {
+	for _, file := range files {
+		if file.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"io"
+	"strconv"
+)
+
+const (
+	// MeasureMaxSize 测速请求允许的最大大小（MB）
+	MeasureMaxSize = 200
+
+	// measureUnit 测速数据的单位大小
+	measureUnit = 1024 * 1024
+)
+
+// measurePattern 测速数据的填充内容，与官方实现保持一致
+var measurePattern = []byte{0x00, 0x66, 0xcc, 0xff}
+
+// patternReader 无限重复输出测速填充内容的Reader
+type patternReader struct {
+	offset int
+}
+
+// Read 实现io.Reader接口
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = measurePattern[r.offset]
+		r.offset = (r.offset + 1) % len(measurePattern)
+	}
+	return len(p), nil
+}
+
+// NewMeasureReader 返回一个输出size MB测速数据的Reader，数据按需生成而不会一次性分配
+func NewMeasureReader(size int) io.Reader {
+	return io.LimitReader(&patternReader{}, MeasureSize(size))
+}
+
+// MeasureSize 返回size MB测速数据的字节数
+func MeasureSize(size int) int64 {
+	return int64(size) * measureUnit
+}
+
+// measurePath 返回测速文件在存储中的相对路径
+func measurePath(size int) string {
+	return "measure/" + strconv.Itoa(size)
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// storageOperations 按存储类型、操作和结果统计的存储操作次数
+	storageOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "storage",
+		Name:      "operations_total",
+		Help:      "Storage operations by backend, operation and result.",
+	}, []string{"backend", "op", "result"})
+
+	// storageDuration 存储操作耗时
+	storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Storage operation latency by backend and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "op"})
+)
+
+// observe 记录一次存储操作的结果与耗时
+func observe(backend, op string, start time.Time, err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// 文件不存在不算存储故障
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	storageOperations.WithLabelValues(backend, op, result).Inc()
+	storageDuration.WithLabelValues(backend, op).Observe(time.Since(start).Seconds())
+}
+
+// instrumented 为存储实现记录各项操作的指标
+type instrumented struct {
+	Storage
+	backend string
+}
+
+// measuredStorage 支持直接提供测速文件地址的存储
+type measuredStorage interface {
+	Storage
+	MeasureURL(ctx context.Context, size int) (string, error)
+}
+
+// instrumentedMeasurer 保留底层存储的MeasureURL能力
+type instrumentedMeasurer struct {
+	instrumented
+	measurer measuredStorage
+}
+
+// instrument 包装存储实现以记录指标，保留底层存储的可选接口
+func instrument(s Storage, backend string) Storage {
+	base := instrumented{Storage: s, backend: backend}
+	if m, ok := s.(measuredStorage); ok {
+		return &instrumentedMeasurer{instrumented: base, measurer: m}
+	}
+	return &base
+}
+
+// Check 检查存储是否可用
+func (s *instrumented) Check(ctx context.Context) (bool, error) {
+	start := time.Now()
+	ok, err := s.Storage.Check(ctx)
+	observe(s.backend, "check", start, err)
+	return ok, err
+}
+
+// Get 获取文件
+func (s *instrumented) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := s.Storage.Get(ctx, hash)
+	observe(s.backend, "get", start, err)
+	return reader, err
+}
+
+// Put 存储文件
+func (s *instrumented) Put(ctx context.Context, hash string, data io.Reader) error {
+	start := time.Now()
+	err := s.Storage.Put(ctx, hash, data)
+	observe(s.backend, "put", start, err)
+	return err
+}
+
+// Delete 删除文件
+func (s *instrumented) Delete(ctx context.Context, hash string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, hash)
+	observe(s.backend, "delete", start, err)
+	return err
+}
+
+// Exists 检查文件是否存在
+func (s *instrumented) Exists(ctx context.Context, hash string) (bool, error) {
+	start := time.Now()
+	ok, err := s.Storage.Exists(ctx, hash)
+	observe(s.backend, "exists", start, err)
+	return ok, err
+}
+
+// GetMissingFiles 获取缺失的文件列表
+func (s *instrumented) GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error) {
+	start := time.Now()
+	missing, err := s.Storage.GetMissingFiles(ctx, files)
+	observe(s.backend, "missing", start, err)
+	return missing, err
+}
+
+// ListFiles 列出所有已存在的文件
+func (s *instrumented) ListFiles(ctx context.Context) ([]*FileInfo, error) {
+	start := time.Now()
+	files, err := s.Storage.ListFiles(ctx)
+	observe(s.backend, "list", start, err)
+	return files, err
+}
+
+// GC 垃圾回收
+func (s *instrumented) GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	start := time.Now()
+	result, err := s.Storage.GC(ctx, files, opts)
+	observe(s.backend, "gc", start, err)
+	return result, err
+}
+
+// MeasureURL 返回测速文件的地址
+func (s *instrumentedMeasurer) MeasureURL(ctx context.Context, size int) (string, error) {
+	start := time.Now()
+	url, err := s.measurer.MeasureURL(ctx, size)
+	observe(s.backend, "measure", start, err)
+	return url, err
+}
@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// S3Storage S3兼容对象存储实现，对象按hash[:2]/hash存放，下载通过预签名URL重定向
+type S3Storage struct {
+	client             *http.Client
+	signer             *s3Signer
+	endpoint           *url.URL
+	bucket             string
+	prefix             string
+	pathStyle          bool
+	presignExpiry      time.Duration
+	multipartThreshold int64
+	partSize           int64
+	logger             *logger.Logger
+}
+
+// s3Error S3返回的错误
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+// Error 实现error接口
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3请求失败，状态码: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3请求失败，状态码: %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// s3ListResult ListObjectsV2的响应
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3InitiateResult 创建分片上传的响应
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// s3CompletePart 完成分片上传时提交的分片
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3CompleteRequest 完成分片上传的请求体
+type s3CompleteRequest struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+// NewS3Storage 创建新的S3存储实例
+func NewS3Storage(cfg config.S3Config, logger *logger.Logger) (*S3Storage, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("无效的S3地址: %s", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("未配置S3存储桶")
+	}
+
+	// 前缀不以斜杠开头，非空时以斜杠结尾
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	// 上传大文件耗时较长，只限制等待响应头的时间
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 60 * time.Second
+
+	return &S3Storage{
+		client: &http.Client{Transport: transport},
+		signer: &s3Signer{
+			accessKey:    cfg.AccessKeyID,
+			secretKey:    cfg.SecretAccessKey,
+			sessionToken: cfg.SessionToken,
+			region:       cfg.Region,
+		},
+		endpoint:           endpoint,
+		bucket:             cfg.Bucket,
+		prefix:             prefix,
+		pathStyle:          cfg.PathStyle,
+		presignExpiry:      time.Duration(cfg.PresignExpirySeconds) * time.Second,
+		multipartThreshold: int64(cfg.MultipartThresholdMB) * 1024 * 1024,
+		partSize:           int64(cfg.PartSizeMB) * 1024 * 1024,
+		logger:             logger,
+	}, nil
+}
+
+// Init 初始化S3存储，确认存储桶存在且可以访问
+func (s *S3Storage) Init(ctx context.Context) error {
+	if _, err := s.Check(ctx); err != nil {
+		return fmt.Errorf("无法访问存储桶 %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// Check 检查存储桶是否可用
+func (s *S3Storage) Check(ctx context.Context) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Get 获取文件，返回预签名URL而不是实际文件内容
+func (s *S3Storage) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return &redirectReadCloser{redirectURL: s.presign(s.hashKey(hash))}, nil
+}
+
+// MeasureURL 确保测速文件已上传，并返回其预签名URL
+func (s *S3Storage) MeasureURL(ctx context.Context, size int) (string, error) {
+	key := s.prefix + measurePath(size)
+
+	// 已存在且大小一致的测速文件可以直接复用
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil || resp.ContentLength != MeasureSize(size) {
+		if err := s.putObject(ctx, key, NewMeasureReader(size), MeasureSize(size)); err != nil {
+			return "", fmt.Errorf("无法上传测速文件 %s: %w", key, err)
+		}
+	}
+
+	return s.presign(key), nil
+}
+
+// Put 存储文件，超过分片阈值时使用分片上传。数据读取失败时不会留下对象
+func (s *S3Storage) Put(ctx context.Context, hash string, data io.Reader) error {
+	key := s.hashKey(hash)
+	data = contextReader(ctx, data)
+
+	// 先读取不超过阈值的数据，读完时直接上传
+	head, err := io.ReadAll(io.LimitReader(data, s.multipartThreshold+1))
+	if err != nil {
+		return fmt.Errorf("无法读取文件数据: %w", err)
+	}
+	if int64(len(head)) <= s.multipartThreshold {
+		if err := s.putObject(ctx, key, bytes.NewReader(head), int64(len(head))); err != nil {
+			return fmt.Errorf("无法上传文件 %s: %w", key, err)
+		}
+		return nil
+	}
+
+	if err := s.putMultipart(ctx, key, io.MultiReader(bytes.NewReader(head), data)); err != nil {
+		return fmt.Errorf("无法上传文件 %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete 删除文件，文件不存在时不返回错误
+func (s *S3Storage) Delete(ctx context.Context, hash string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.hashKey(hash), nil, nil, 0)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Exists 检查文件是否存在
+func (s *S3Storage) Exists(ctx context.Context, hash string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.hashKey(hash), nil, nil, 0)
+	if err != nil {
+		if isS3NotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// WriteFile 写入文件
+func (s *S3Storage) WriteFile(ctx context.Context, filePath string, content []byte, fileInfo *FileInfo) error {
+	key := s.prefix + strings.TrimPrefix(strings.ReplaceAll(filePath, "\\", "/"), "/")
+	if err := s.putObject(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+		return fmt.Errorf("无法写入文件 %s: %w", key, err)
+	}
+	return nil
+}
+
+// ListFiles 列出所有已存在的文件
+func (s *S3Storage) ListFiles(ctx context.Context) ([]*FileInfo, error) {
+	var files []*FileInfo
+
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("无法列出对象: %w", err)
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("无法解析对象列表: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			// 只保留符合hash[:2]/hash结构的对象
+			rel := strings.TrimPrefix(object.Key, s.prefix)
+			dir, hash, ok := strings.Cut(rel, "/")
+			if !ok || len(dir) != 2 || strings.Contains(hash, "/") || !strings.HasPrefix(hash, dir) {
+				continue
+			}
+			files = append(files, &FileInfo{
+				Hash: hash,
+				Size: object.Size,
+				Path: object.Key,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return files, nil
+}
+
+// GetMissingFiles 获取缺失的文件列表
+func (s *S3Storage) GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error) {
+	// 获取所有已存在的文件
+	existingFiles, err := s.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("无法列出已存在的文件: %w", err)
+	}
+
+	// 创建一个map来存储已存在的文件
+	existingMap := make(map[string]bool)
+	for _, file := range existingFiles {
+		existingMap[file.Hash] = true
+	}
+
+	// 找出缺失的文件
+	var missing []*FileInfo
+	for _, file := range files {
+		if !existingMap[file.Hash] {
+			missing = append(missing, file)
+		}
+	}
+
+	return missing, nil
+}
+
+// GC 垃圾回收，删除不在files列表中的文件
+func (s *S3Storage) GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	return collectGarbage(ctx, s, files, opts)
+}
+
+// hashKey 返回文件对应的对象键
+func (s *S3Storage) hashKey(hash string) string {
+	return s.prefix + hash[:2] + "/" + hash
+}
+
+// objectURL 返回对象的地址，key为空时返回存储桶的地址
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	path += "/" + key
+
+	u.Path = path
+	u.RawPath = s3Escape(path, false)
+	u.RawQuery = ""
+	return &u
+}
+
+// presign 返回对象的预签名下载地址
+func (s *S3Storage) presign(key string) string {
+	return s.signer.presign(http.MethodGet, s.objectURL(key), s.presignExpiry, time.Now())
+}
+
+// do 签名并发送请求，非2xx响应以*s3Error返回
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建请求: %w", err)
+	}
+	// 保留签名时使用的编码后的路径
+	req.URL = u
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.signer.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+	return resp, nil
+}
+
+// putObject 以单个请求上传已知大小的数据
+func (s *S3Storage) putObject(ctx context.Context, key string, data io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putMultipart 使用分片上传写入数据，任一步骤失败时中止上传
+func (s *S3Storage) putMultipart(ctx context.Context, key string, data io.Reader) (err error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return fmt.Errorf("无法创建分片上传: %w", err)
+	}
+	var initiate s3InitiateResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil || initiate.UploadID == "" {
+		return fmt.Errorf("无法解析分片上传响应: %w", err)
+	}
+	uploadID := initiate.UploadID
+
+	// 失败时中止上传，释放已上传的分片。ctx可能已被取消，因此使用独立的上下文
+	defer func() {
+		if err == nil {
+			return
+		}
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		resp, abortErr := s.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0)
+		if abortErr != nil {
+			s.logger.Warn("无法中止分片上传 %s: %v", key, abortErr)
+			return
+		}
+		resp.Body.Close()
+	}()
+
+	var parts []s3CompletePart
+	buf := make([]byte, s.partSize)
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(data, buf)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+			return fmt.Errorf("无法读取文件数据: %w", readErr)
+		}
+		if n == 0 {
+			break
+		}
+
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, query, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return fmt.Errorf("无法上传第 %d 个分片: %w", partNumber, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, s3CompletePart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(s3CompleteRequest{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("无法序列化分片列表: %w", err)
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("无法完成分片上传: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 完成分片上传时，S3可能在返回200之后才在响应体中报告错误
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("无法读取完成分片上传的响应: %w", err)
+	}
+	var completeErr s3Error
+	if xml.Unmarshal(respBody, &completeErr) == nil && completeErr.Code != "" {
+		completeErr.StatusCode = resp.StatusCode
+		return fmt.Errorf("无法完成分片上传: %w", &completeErr)
+	}
+
+	return nil
+}
+
+// readS3Error 将错误响应转换为*s3Error
+func readS3Error(resp *http.Response) error {
+	s3Err := &s3Error{StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = xml.Unmarshal(body, s3Err)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", os.ErrNotExist, s3Err)
+	}
+	return s3Err
+}
+
+// isS3NotFound 判断错误是否表示对象不存在
+func isS3NotFound(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
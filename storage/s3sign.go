@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3Algorithm SigV4签名算法名称
+	s3Algorithm = "AWS4-HMAC-SHA256"
+	// s3UnsignedPayload 不对请求体计算哈希，上传时无需先读完数据
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// s3TimeFormat 签名使用的时间格式
+	s3TimeFormat = "20060102T150405Z"
+	// s3DateFormat 签名范围使用的日期格式
+	s3DateFormat = "20060102"
+)
+
+// s3Signer 按AWS Signature Version 4为S3请求签名
+type s3Signer struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+}
+
+// sign 为请求添加签名头，请求的URL在签名后不能再修改
+func (s *s3Signer) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// 参与签名的头部，按名称排序
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+// presign 生成在expiry内有效的预签名URL，u的查询参数会被替换
+func (s *s3Signer) presign(method string, u *url.URL, expiry time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format(s3TimeFormat)
+	scope := s.scope(now)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	rawQuery := s3CanonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		rawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	signed := *u
+	signed.RawQuery = rawQuery + "&X-Amz-Signature=" + signature
+	return signed.String()
+}
+
+// scope 返回签名范围
+func (s *s3Signer) scope(now time.Time) string {
+	return now.Format(s3DateFormat) + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature 计算规范请求的签名
+func (s *s3Signer) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery 按SigV4的要求编码并排序查询参数
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape 按SigV4的规则进行URI编码，只保留非保留字符，encodeSlash为false时保留斜杠
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
 
 	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
 )
 
 // FileInfo 文件信息
@@ -14,51 +16,76 @@ type FileInfo struct {
 	Path string `json:"path"`
 }
 
-// Storage 定义存储接口
+// Storage 定义存储接口，涉及网络或磁盘的操作均遵循ctx的取消和超时
 type Storage interface {
 	// Init 初始化存储
-	Init() error
+	Init(ctx context.Context) error
 
 	// Check 检查存储是否可用
-	Check() (bool, error)
+	Check(ctx context.Context) (bool, error)
 
 	// Get 获取文件
-	Get(hash string) (io.ReadCloser, error)
+	Get(ctx context.Context, hash string) (io.ReadCloser, error)
 
 	// Put 存储文件
-	Put(hash string, data io.Reader) error
+	Put(ctx context.Context, hash string, data io.Reader) error
 
 	// Delete 删除文件
-	Delete(hash string) error
+	Delete(ctx context.Context, hash string) error
 
 	// Exists 检查文件是否存在
-	Exists(hash string) (bool, error)
+	Exists(ctx context.Context, hash string) (bool, error)
 
 	// WriteFile 写入文件
-	WriteFile(path string, content []byte, fileInfo *FileInfo) error
+	WriteFile(ctx context.Context, path string, content []byte, fileInfo *FileInfo) error
 
 	// GetMissingFiles 获取缺失的文件列表
-	GetMissingFiles(files []*FileInfo) ([]*FileInfo, error)
+	GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error)
 
 	// ListFiles 列出所有已存在的文件
-	ListFiles() ([]*FileInfo, error)
+	ListFiles(ctx context.Context) ([]*FileInfo, error)
 
-	// GC 垃圾回收
-	GC(files []*FileInfo) error
-
-	// GetLastModified 获取存储中所有文件的最新修改时间（Unix时间戳）
-	GetLastModified() (int64, error)
+	// GC 垃圾回收，删除不在files列表中的文件
+	GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error)
 }
 
-func NewStorage(cfg *config.Config) (Storage, error) {
+// NewStorage 根据配置创建存储实例，返回的实例会记录各项操作的指标
+func NewStorage(cfg *config.Config, logger *logger.Logger) (Storage, error) {
+	var store Storage
 	switch cfg.Storage.Type {
 	case "file":
-		return NewFileStorage(cfg.Storage.Path), nil
+		store = NewFileStorage(cfg.Storage.Path, logger)
 	case "webdav":
-		return NewWebDAVStorage(cfg.Storage.WebDAV), nil
+		store = NewWebDAVStorage(cfg.Storage.WebDAV, logger)
 	case "alist":
-		return NewAListStorage(cfg.Storage.AList), nil
+		store = NewAListStorage(cfg.Storage.AList, logger)
+	case "s3":
+		s3, err := NewS3Storage(cfg.Storage.S3, logger)
+		if err != nil {
+			return nil, err
+		}
+		store = s3
 	default:
 		return nil, fmt.Errorf("不支持的存储类型: %s", cfg.Storage.Type)
 	}
+	return instrument(store, cfg.Storage.Type), nil
+}
+
+// ctxReader 在ctx被取消后停止读取的io.Reader
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// contextReader 包装r，使拷贝数据的过程能响应ctx的取消
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+// Read 读取数据，ctx已取消时返回ctx的错误
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
 }
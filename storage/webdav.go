@@ -1,15 +1,20 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/studio-b12/gowebdav"
 	"github.com/uright008/go-openbmclapi-reborn/config"
+	"github.com/uright008/go-openbmclapi-reborn/logger"
 )
 
 // WebDAVStorage WebDAV存储实现
@@ -19,10 +24,11 @@ type WebDAVStorage struct {
 	username string
 	password string
 	path     string
+	logger   *logger.Logger
 }
 
 // NewWebDAVStorage 创建新的WebDAV存储实例
-func NewWebDAVStorage(cfg config.WebDAVConfig) *WebDAVStorage {
+func NewWebDAVStorage(cfg config.WebDAVConfig, logger *logger.Logger) *WebDAVStorage {
 	client := gowebdav.NewClient(cfg.Endpoint, cfg.Username, cfg.Password)
 
 	// 确保路径以斜杠结尾
@@ -37,22 +43,23 @@ func NewWebDAVStorage(cfg config.WebDAVConfig) *WebDAVStorage {
 		username: cfg.Username,
 		password: cfg.Password,
 		path:     path,
+		logger:   logger,
 	}
 }
 
 // Init 初始化WebDAV存储
-func (w *WebDAVStorage) Init() error {
+func (w *WebDAVStorage) Init(ctx context.Context) error {
 	// 检查连接是否正常
-	err := w.retryOnLock(func() error {
-		return w.client.Connect()
+	err := w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).Connect()
 	})
 	if err != nil {
 		return fmt.Errorf("无法连接到WebDAV服务器: %w", err)
 	}
 
 	// 确保基础目录存在
-	err = w.retryOnLock(func() error {
-		return w.client.MkdirAll(w.path, 0755)
+	err = w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).MkdirAll(w.path, 0755)
 	})
 	if err != nil {
 		return fmt.Errorf("无法创建基础目录 %s: %w", w.path, err)
@@ -62,9 +69,9 @@ func (w *WebDAVStorage) Init() error {
 }
 
 // Check 检查WebDAV存储是否可用
-func (w *WebDAVStorage) Check() (bool, error) {
+func (w *WebDAVStorage) Check(ctx context.Context) (bool, error) {
 	// 尝试列出来基础目录内容
-	_, err := w.client.ReadDir(w.path)
+	_, err := w.clientFor(ctx).ReadDir(w.path)
 	if err != nil {
 		return false, err
 	}
@@ -73,10 +80,57 @@ func (w *WebDAVStorage) Check() (bool, error) {
 }
 
 // Get 获取文件，返回重定向URL而不是实际文件内容
-func (w *WebDAVStorage) Get(hash string) (io.ReadCloser, error) {
+func (w *WebDAVStorage) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
 	// 构建文件在WebDAV服务器上的路径
 	filePath := filepath.Join(w.path, hash[:2], hash)
 
+	redirectURL, err := w.fileURL(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 返回一个包含重定向URL的特殊ReadCloser
+	return &redirectReadCloser{redirectURL: redirectURL}, nil
+}
+
+// MeasureURL 确保测速文件已上传，并返回其可访问的URL
+func (w *WebDAVStorage) MeasureURL(ctx context.Context, size int) (string, error) {
+	filePath := strings.ReplaceAll(filepath.Join(w.path, measurePath(size)), "\\", "/")
+
+	// 已存在且大小一致的测速文件可以直接复用
+	info, err := w.clientFor(ctx).Stat(filePath)
+	if err != nil || info.Size() != MeasureSize(size) {
+		dir := filepath.Dir(filePath)
+		err = w.retryOnLock(ctx, func() error {
+			return w.clientFor(ctx).MkdirAll(dir, 0755)
+		})
+		if err != nil {
+			return "", fmt.Errorf("无法创建目录 %s: %w", dir, err)
+		}
+
+		err = w.retryOnLock(ctx, func() error {
+			return w.clientFor(ctx).WriteStream(filePath, NewMeasureReader(size), 0644)
+		})
+		if err != nil {
+			return "", fmt.Errorf("无法写入测速文件 %s: %w", filePath, err)
+		}
+	}
+
+	return w.fileURL(filePath)
+}
+
+// clientFor 返回一个请求绑定到ctx的客户端副本，
+// gowebdav的方法本身不接受context，通过拦截器为每个请求设置ctx
+func (w *WebDAVStorage) clientFor(ctx context.Context) *gowebdav.Client {
+	client := *w.client
+	client.SetInterceptor(func(method string, rq *http.Request) {
+		*rq = *rq.WithContext(ctx)
+	})
+	return &client
+}
+
+// fileURL 构建WebDAV服务器上文件的可访问URL
+func (w *WebDAVStorage) fileURL(filePath string) (string, error) {
 	// 构建可访问的URL
 	// 移除endpoint末尾的斜杠，添加文件路径
 	endpoint := strings.TrimSuffix(w.endpoint, "/")
@@ -92,11 +146,10 @@ func (w *WebDAVStorage) Get(hash string) (io.ReadCloser, error) {
 	// URL编码
 	parsedURL, err := url.Parse(fullURL)
 	if err != nil {
-		return nil, fmt.Errorf("无法解析URL %s: %w", fullURL, err)
+		return "", fmt.Errorf("无法解析URL %s: %w", fullURL, err)
 	}
 
-	// 返回一个包含重定向URL的特殊ReadCloser
-	return &redirectReadCloser{redirectURL: parsedURL.String()}, nil
+	return parsedURL.String(), nil
 }
 
 // redirectReadCloser 一个特殊的ReadCloser，包含重定向URL
@@ -104,6 +157,11 @@ type redirectReadCloser struct {
 	redirectURL string
 }
 
+// GetRedirectURL 返回客户端应被重定向到的URL
+func (r *redirectReadCloser) GetRedirectURL() string {
+	return r.redirectURL
+}
+
 // Read 实现io.Reader接口
 func (r *redirectReadCloser) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("should redirect to %s", r.redirectURL)
@@ -115,26 +173,26 @@ func (r *redirectReadCloser) Close() error {
 }
 
 // Put 存储文件
-func (w *WebDAVStorage) Put(hash string, data io.Reader) error {
+func (w *WebDAVStorage) Put(ctx context.Context, hash string, data io.Reader) error {
 	// 创建目录
 	dir := filepath.Join(w.path, hash[:2])
-	err := w.retryOnLock(func() error {
-		return w.client.MkdirAll(dir, 0755)
+	err := w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).MkdirAll(dir, 0755)
 	})
 	if err != nil {
 		return fmt.Errorf("无法创建目录 %s: %w", dir, err)
 	}
 
 	// 读取数据
-	fileData, err := io.ReadAll(data)
+	fileData, err := io.ReadAll(contextReader(ctx, data))
 	if err != nil {
 		return fmt.Errorf("无法读取文件数据: %w", err)
 	}
 
 	// 上传文件
 	filePath := strings.ReplaceAll(filepath.Join(dir, hash), "\\", "/")
-	err = w.retryOnLock(func() error {
-		return w.client.Write(filePath, fileData, 0644)
+	err = w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).Write(filePath, fileData, 0644)
 	})
 	if err != nil {
 		return fmt.Errorf("无法写入文件 %s: %w", filePath, err)
@@ -144,17 +202,17 @@ func (w *WebDAVStorage) Put(hash string, data io.Reader) error {
 }
 
 // Delete 删除文件
-func (w *WebDAVStorage) Delete(hash string) error {
+func (w *WebDAVStorage) Delete(ctx context.Context, hash string) error {
 	// 构建文件路径
 	filePath := filepath.Join(w.path, hash[:2], hash)
 
 	// 执行删除操作
-	err := w.retryOnLock(func() error {
-		return w.client.Remove(filePath)
+	err := w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).Remove(filePath)
 	})
 
 	// 如果是404错误（文件不存在），我们不返回错误
-	if err != nil && (strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "not found")) {
+	if err != nil && isWebDAVNotFound(err) {
 		return nil
 	}
 
@@ -162,16 +220,16 @@ func (w *WebDAVStorage) Delete(hash string) error {
 }
 
 // Exists 检查文件是否存在
-func (w *WebDAVStorage) Exists(hash string) (bool, error) {
+func (w *WebDAVStorage) Exists(ctx context.Context, hash string) (bool, error) {
 	filePath := filepath.Join(w.path, hash[:2], hash)
-	err := w.retryOnLock(func() error {
-		_, err := w.client.Stat(filePath)
+	err := w.retryOnLock(ctx, func() error {
+		_, err := w.clientFor(ctx).Stat(filePath)
 		return err
 	})
 
 	if err != nil {
 		// 检查是否是文件不存在错误
-		if strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "not found") {
+		if isWebDAVNotFound(err) {
 			return false, nil
 		}
 		return false, err
@@ -180,8 +238,14 @@ func (w *WebDAVStorage) Exists(hash string) (bool, error) {
 	return true, nil
 }
 
+// isWebDAVNotFound 判断错误是否表示文件不存在
+func isWebDAVNotFound(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || gowebdav.IsErrNotFound(err) ||
+		strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "not found")
+}
+
 // retryOnLock 在遇到423锁定错误时重试操作
-func (w *WebDAVStorage) retryOnLock(operation func() error) error {
+func (w *WebDAVStorage) retryOnLock(ctx context.Context, operation func() error) error {
 	maxRetries := 5
 	for i := 0; i < maxRetries; i++ {
 		err := operation()
@@ -190,9 +254,13 @@ func (w *WebDAVStorage) retryOnLock(operation func() error) error {
 			if strings.Contains(err.Error(), "423") || strings.Contains(err.Error(), "Locked") {
 				// 如果不是最后一次重试，则等待1分钟后重试
 				if i < maxRetries-1 {
-					fmt.Printf("[INFO] 遇到423锁定错误，等待1分钟后重试 (%d/%d)\n", i+1, maxRetries-1)
-					time.Sleep(1 * time.Minute)
-					continue
+					w.logger.Info("遇到423锁定错误，等待1分钟后重试 (%d/%d)", i+1, maxRetries-1)
+					select {
+					case <-time.After(1 * time.Minute):
+						continue
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
 			}
 			// 如果不是423错误或已达到最大重试次数，则返回错误
@@ -205,20 +273,20 @@ func (w *WebDAVStorage) retryOnLock(operation func() error) error {
 }
 
 // WriteFile 写入文件
-func (w *WebDAVStorage) WriteFile(filePath string, content []byte, fileInfo *FileInfo) error {
+func (w *WebDAVStorage) WriteFile(ctx context.Context, filePath string, content []byte, fileInfo *FileInfo) error {
 	fullPath := filepath.Join(w.path, filePath)
 	// 确保目录存在
 	dir := filepath.Dir(fullPath)
-	err := w.retryOnLock(func() error {
-		return w.client.MkdirAll(dir, 0755)
+	err := w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).MkdirAll(dir, 0755)
 	})
 	if err != nil {
 		return fmt.Errorf("无法创建目录: %w", err)
 	}
 
 	// 写入文件
-	err = w.retryOnLock(func() error {
-		return w.client.Write(fullPath, content, 0644)
+	err = w.retryOnLock(ctx, func() error {
+		return w.clientFor(ctx).Write(fullPath, content, 0644)
 	})
 	if err != nil {
 		return fmt.Errorf("无法写入文件 %s: %w", fullPath, err)
@@ -227,11 +295,11 @@ func (w *WebDAVStorage) WriteFile(filePath string, content []byte, fileInfo *Fil
 }
 
 // ListFiles 列出所有已存在的文件
-func (w *WebDAVStorage) ListFiles() ([]*FileInfo, error) {
+func (w *WebDAVStorage) ListFiles(ctx context.Context) ([]*FileInfo, error) {
 	var files []*FileInfo
 
 	// 遍历存储目录，获取所有已存在的文件
-	err := w.walkDir(w.path, "", &files)
+	err := w.walkDir(ctx, w.path, "", &files)
 	if err != nil {
 		return nil, fmt.Errorf("遍历目录失败: %w", err)
 	}
@@ -240,9 +308,13 @@ func (w *WebDAVStorage) ListFiles() ([]*FileInfo, error) {
 }
 
 // walkDir 递归遍历目录
-func (w *WebDAVStorage) walkDir(basePath, relPath string, files *[]*FileInfo) error {
+func (w *WebDAVStorage) walkDir(ctx context.Context, basePath, relPath string, files *[]*FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	currentPath := filepath.Join(basePath, relPath)
-	entries, err := w.client.ReadDir(currentPath)
+	entries, err := w.clientFor(ctx).ReadDir(currentPath)
 	if err != nil {
 		// 忽略无法访问的目录
 		return nil
@@ -253,7 +325,7 @@ func (w *WebDAVStorage) walkDir(basePath, relPath string, files *[]*FileInfo) er
 
 		if entry.IsDir() {
 			// 递归处理子目录
-			err := w.walkDir(basePath, entryRelPath, files)
+			err := w.walkDir(ctx, basePath, entryRelPath, files)
 			if err != nil {
 				return err
 			}
@@ -282,9 +354,9 @@ func (w *WebDAVStorage) walkDir(basePath, relPath string, files *[]*FileInfo) er
 }
 
 // GetMissingFiles 获取缺失的文件列表
-func (w *WebDAVStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error) {
+func (w *WebDAVStorage) GetMissingFiles(ctx context.Context, files []*FileInfo) ([]*FileInfo, error) {
 	// 获取所有已存在的文件
-	existingFiles, err := w.ListFiles()
+	existingFiles, err := w.ListFiles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("无法列出已存在的文件: %w", err)
 	}
@@ -306,77 +378,7 @@ func (w *WebDAVStorage) GetMissingFiles(files []*FileInfo) ([]*FileInfo, error)
 	return missing, nil
 }
 
-// GC 垃圾回收
-func (w *WebDAVStorage) GC(files []*FileInfo) error {
-	// 获取所有已存在的文件
-	existingFiles, err := w.ListFiles()
-	if err != nil {
-		return fmt.Errorf("无法列出已存在的文件: %w", err)
-	}
-
-	// 创建一个map来存储需要保留的文件
-	keepMap := make(map[string]bool)
-	for _, file := range files {
-		keepMap[file.Hash] = true
-	}
-
-	// 删除不需要的文件
-	var deletedCount int
-	for _, file := range existingFiles {
-		if !keepMap[file.Hash] {
-			err := w.Delete(file.Hash)
-			if err != nil {
-				// 记录错误但继续删除其他文件
-				fmt.Printf("无法删除文件 %s: %v\n", file.Hash, err)
-				continue
-			}
-			deletedCount++
-		}
-	}
-
-	fmt.Printf("垃圾回收完成，删除了 %d 个文件\n", deletedCount)
-	return nil
-}
-
-// GetLastModified 获取存储中所有文件的最新修改时间（Unix时间戳）
-func (w *WebDAVStorage) GetLastModified() (int64, error) {
-	var lastModified int64
-
-	// 遍历所有文件查找最新修改时间
-	err := w.walkDirModified(w.path, "", &lastModified)
-	if err != nil {
-		return 0, fmt.Errorf("遍历目录失败: %w", err)
-	}
-
-	return lastModified, nil
-}
-
-// walkDirModified 递归遍历目录查找最新修改时间
-func (w *WebDAVStorage) walkDirModified(basePath, relPath string, lastModified *int64) error {
-	currentPath := filepath.Join(basePath, relPath)
-	entries, err := w.client.ReadDir(currentPath)
-	if err != nil {
-		// 忽略无法访问的目录
-		return nil
-	}
-
-	for _, entry := range entries {
-		entryRelPath := filepath.Join(relPath, entry.Name())
-
-		if entry.IsDir() {
-			// 递归处理子目录
-			err := w.walkDirModified(basePath, entryRelPath, lastModified)
-			if err != nil {
-				return err
-			}
-		} else {
-			// 检查文件修改时间
-			modTime := entry.ModTime().Unix()
-			if modTime > *lastModified {
-				*lastModified = modTime
-			}
-		}
-	}
-
-	return nil
+// GC 垃圾回收，删除不在files列表中的文件
+func (w *WebDAVStorage) GC(ctx context.Context, files []*FileInfo, opts GCOptions) (*GCResult, error) {
+	return collectGarbage(ctx, w, files, opts)
 }
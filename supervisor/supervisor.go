@@ -0,0 +1,128 @@
+// Package supervisor 管理程序中长期运行的组件，负责统一启动和在退出时按顺序停止
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// component 一个受管理的组件
+type component struct {
+	name string
+	run  func() error
+	stop func(ctx context.Context) error
+}
+
+// exit 组件run函数的返回结果
+type exit struct {
+	name string
+	err  error
+}
+
+// Group 一组组件，任一组件退出或上下文取消时停止所有组件
+type Group struct {
+	logger     *logger.Logger
+	components []component
+}
+
+// NewGroup 创建组件组
+func NewGroup(logger *logger.Logger) *Group {
+	return &Group{logger: logger}
+}
+
+// Add 添加组件。run阻塞运行组件直到其被停止，为nil时表示组件已在别处启动，只需在退出时停止；
+// stop用于停止组件，需在ctx到期前返回。组件按添加的相反顺序停止
+func (g *Group) Add(name string, run func() error, stop func(ctx context.Context) error) {
+	g.components = append(g.components, component{name: name, run: run, stop: stop})
+}
+
+// Run 启动所有组件并阻塞，直到ctx被取消或任一组件退出，
+// 然后在timeout内按添加的相反顺序停止所有组件。返回最先异常退出的组件的错误
+func (g *Group) Run(ctx context.Context, timeout time.Duration) error {
+	exits := make(chan exit, len(g.components))
+	running := 0
+	for _, c := range g.components {
+		if c.run == nil {
+			continue
+		}
+		running++
+		go func(c component) {
+			exits <- exit{name: c.name, err: c.run()}
+		}(c)
+	}
+
+	// 等待关闭信号或组件退出
+	var runErr error
+	select {
+	case <-ctx.Done():
+		g.logger.Info("收到关闭信号，正在关闭...")
+	case e := <-exits:
+		running--
+		runErr = fmt.Errorf("%s 意外退出: %w", e.name, e.err)
+		if e.err == nil {
+			runErr = fmt.Errorf("%s 意外退出", e.name)
+		}
+		g.logger.Error("%v，正在关闭...", runErr)
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	g.stopAll(stopCtx)
+
+	// 等待所有组件的run返回
+	for running > 0 {
+		select {
+		case e := <-exits:
+			running--
+			if e.err != nil {
+				g.logger.Debug("%s 已退出: %v", e.name, e.err)
+			}
+		case <-stopCtx.Done():
+			g.logger.Warn("仍有 %d 个组件未能在 %v 内退出", running, timeout)
+			return errors.Join(runErr, stopCtx.Err())
+		}
+	}
+
+	return runErr
+}
+
+// Stop 在timeout内按添加的相反顺序停止所有组件，用于Run之前的启动阶段失败时清理已添加的组件
+func (g *Group) Stop(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	g.stopAll(ctx)
+}
+
+// stopAll 按添加的相反顺序停止所有组件
+func (g *Group) stopAll(ctx context.Context) {
+	for i := len(g.components) - 1; i >= 0; i-- {
+		g.stop(ctx, g.components[i])
+	}
+}
+
+// stop 停止单个组件，超过期限时不再等待
+func (g *Group) stop(ctx context.Context, c component) {
+	if c.stop == nil {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			g.logger.Error("停止 %s 时出错: %v", c.name, err)
+		} else {
+			g.logger.Debug("%s 已停止", c.name)
+		}
+	case <-ctx.Done():
+		g.logger.Warn("停止 %s 超时", c.name)
+	}
+}
@@ -0,0 +1,135 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+// recorder 记录组件停止的顺序
+type recorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+func (r *recorder) stop(name string) func(context.Context) error {
+	return func(context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.stopped = append(r.stopped, name)
+		return nil
+	}
+}
+
+func (r *recorder) order() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.stopped, ",")
+}
+
+// blocking 返回阻塞到stop被调用的组件
+func blocking(r *recorder, name string) (func() error, func(context.Context) error) {
+	done := make(chan struct{})
+	var once sync.Once
+	run := func() error {
+		<-done
+		return nil
+	}
+	stop := func(ctx context.Context) error {
+		once.Do(func() { close(done) })
+		return r.stop(name)(ctx)
+	}
+	return run, stop
+}
+
+func TestRunStopsInReverseOrder(t *testing.T) {
+	r := &recorder{}
+	g := NewGroup(logger.New(false))
+	g.Add("a", nil, r.stop("a"))
+	run, stop := blocking(r, "b")
+	g.Add("b", run, stop)
+	g.Add("c", nil, nil)
+	run, stop = blocking(r, "d")
+	g.Add("d", run, stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Run(ctx, time.Second); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := r.order(); got != "d,b,a" {
+		t.Errorf("停止顺序 = %s, want d,b,a", got)
+	}
+}
+
+func TestRunComponentExit(t *testing.T) {
+	r := &recorder{}
+	g := NewGroup(logger.New(false))
+	run, stop := blocking(r, "a")
+	g.Add("a", run, stop)
+	errFailed := errors.New("监听失败")
+	g.Add("b", func() error { return errFailed }, r.stop("b"))
+
+	err := g.Run(context.Background(), time.Second)
+	if !errors.Is(err, errFailed) || !strings.Contains(err.Error(), "b") {
+		t.Fatalf("Run error = %v, want 包含 b 的退出错误", err)
+	}
+	if got := r.order(); got != "b,a" {
+		t.Errorf("停止顺序 = %s, want b,a", got)
+	}
+}
+
+func TestRunStopTimeout(t *testing.T) {
+	r := &recorder{}
+	g := NewGroup(logger.New(false))
+	g.Add("a", nil, r.stop("a"))
+
+	// 忽略ctx、永远不返回的组件
+	hang := make(chan struct{})
+	defer close(hang)
+	g.Add("b", func() error {
+		<-hang
+		return nil
+	}, func(context.Context) error {
+		<-hang
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := g.Run(ctx, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run 在超时后等待了 %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// 超时的组件不影响之后的组件被停止，期限已过时不等待其返回
+	deadline := time.Now().Add(time.Second)
+	for r.order() != "a" {
+		if time.Now().After(deadline) {
+			t.Fatalf("停止顺序 = %s, want a", r.order())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStop(t *testing.T) {
+	r := &recorder{}
+	g := NewGroup(logger.New(false))
+	g.Add("a", nil, r.stop("a"))
+	g.Add("b", nil, r.stop("b"))
+	g.Add("c", nil, r.stop("c"))
+
+	g.Stop(time.Second)
+	if got := r.order(); got != "c,b,a" {
+		t.Errorf("停止顺序 = %s, want c,b,a", got)
+	}
+}
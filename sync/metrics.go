@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// syncFilesGauge 当前（或上一次）同步需要下载的文件数
+	syncFilesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "files",
+		Help:      "Number of files to download in the current or last sync.",
+	})
+
+	// syncDoneGauge 当前（或上一次）同步已处理完成的文件数
+	syncDoneGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "files_done",
+		Help:      "Number of files finished in the current or last sync.",
+	})
+
+	// syncQueueDepth 等待下载的文件数
+	syncQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "queue_depth",
+		Help:      "Number of files waiting for a download slot.",
+	})
+
+	// syncInFlight 正在下载的文件数
+	syncInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "downloads_in_flight",
+		Help:      "Number of files currently being downloaded.",
+	})
+
+	// syncDownloads 按结果统计的文件下载次数，重试后的最终结果只计一次
+	syncDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "downloads_total",
+		Help:      "File downloads by final result.",
+	}, []string{"result"})
+
+	// syncDownloadBytes 成功下载的字节数
+	syncDownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "download_bytes_total",
+		Help:      "Bytes of successfully downloaded files.",
+	})
+
+	// syncRuns 按结果统计的同步次数
+	syncRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "runs_total",
+		Help:      "Sync runs by result.",
+	}, []string{"result"})
+
+	// syncLastSuccess 上一次完全成功同步的时间
+	syncLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openbmclapi",
+		Subsystem: "sync",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last fully successful sync.",
+	})
+)
@@ -0,0 +1,93 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// syncState 持久化到磁盘的同步状态
+type syncState struct {
+	// LastModified 上一次完全成功的同步中文件的最大mtime
+	LastModified int64 `json:"lastModified"`
+	// UpdatedAt 状态的保存时间
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// stateStore 在磁盘上保存增量同步的水位
+type stateStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// newStateStore 创建同步状态存储
+func newStateStore(path string) *stateStore {
+	return &stateStore{path: path}
+}
+
+// Load 读取上次成功同步的水位，状态文件不存在时返回0以获取完整列表
+func (s *stateStore) Load() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("无法读取同步状态 %s: %w", s.path, err)
+	}
+
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, fmt.Errorf("同步状态文件 %s 已损坏: %w", s.path, err)
+	}
+	if state.LastModified < 0 {
+		return 0, fmt.Errorf("同步状态文件 %s 中的水位无效: %d", s.path, state.LastModified)
+	}
+
+	return state.LastModified, nil
+}
+
+// Save 保存新的水位，先写临时文件再重命名，避免写入中断导致状态损坏
+func (s *stateStore) Save(lastModified int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(syncState{
+		LastModified: lastModified,
+		UpdatedAt:    time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法序列化同步状态: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("无法创建同步状态目录: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("无法写入同步状态: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("无法保存同步状态: %w", err)
+	}
+
+	return nil
+}
+
+// Reset 删除已保存的水位，下一次同步将获取完整文件列表
+func (s *stateStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("无法删除同步状态: %w", err)
+	}
+	return nil
+}
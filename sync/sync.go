@@ -1,7 +1,9 @@
 package sync
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"github.com/linkedin/goavro/v2"
 	"github.com/uright008/go-openbmclapi-reborn/config"
 	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
 	"github.com/uright008/go-openbmclapi-reborn/storage"
 	"github.com/uright008/go-openbmclapi-reborn/token"
 )
@@ -23,6 +26,14 @@ const (
 	version = "1.0.0"
 )
 
+// 受熔断器保护的依赖名称
+const (
+	// DependencyCenter 中心服务器
+	DependencyCenter = "center"
+	// DependencyStorage 本地或远程存储
+	DependencyStorage = "storage"
+)
+
 // File 表示一个需要同步的文件
 type File struct {
 	Path  string `json:"path"`
@@ -38,39 +49,52 @@ type SyncManager struct {
 	client      *http.Client
 	serverURL   string
 	logger      *logger.Logger
-	errorMgr    *ErrorRetryManager
+	policy      *resilience.Policy
 	config      *config.SyncConfig
 	debugConfig *config.DebugConfig
+	// fileSizes 记录中心服务器文件列表中每个文件的大小（hash -> size）
+	fileSizes sync.Map
+	// state 保存增量同步的水位
+	state *stateStore
 }
 
-// NewSyncManager 创建新的同步管理器
-func NewSyncManager(storage storage.Storage, tokenMgr *token.TokenManager, logger *logger.Logger, syncConfig *config.SyncConfig, debugConfig *config.DebugConfig) *SyncManager {
+// NewSyncManager 创建新的同步管理器，statePath为同步水位的保存路径，
+// 对中心服务器和存储的访问受policy中对应熔断器的保护
+func NewSyncManager(storage storage.Storage, tokenMgr *token.TokenManager, logger *logger.Logger, serverURL string, policy *resilience.Policy, syncConfig *config.SyncConfig, debugConfig *config.DebugConfig, statePath string) *SyncManager {
 	return &SyncManager{
 		storage:     storage,
 		tokenMgr:    tokenMgr,
 		client:      &http.Client{Timeout: 30 * time.Second},
-		serverURL:   "https://openbmclapi.bangbang93.com",
+		serverURL:   serverURL,
 		logger:      logger,
-		errorMgr:    NewErrorRetryManager(5, logger),
+		policy:      policy,
 		config:      syncConfig,
 		debugConfig: debugConfig,
+		state:       newStateStore(statePath),
 	}
 }
 
-// doRequest 执行HTTP请求的统一方法
-func (sm *SyncManager) doRequest(method, path string, params map[string]string) (*http.Response, error) {
+// doRequest 执行HTTP请求的统一方法，请求遵循ctx的取消和超时
+func (sm *SyncManager) doRequest(ctx context.Context, method, path string, params map[string]string) (*http.Response, error) {
 	// 构建完整URL
 	url := fmt.Sprintf("%s/%s", sm.serverURL, path)
 
 	// 创建请求
-	req, err := http.NewRequest(method, url, nil)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("无法创建请求: %w", err)
 	}
 
+	// 中心服务器熔断时直接失败，不再发起请求
+	breaker := sm.policy.Breaker(DependencyCenter)
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
 	// 获取认证令牌
-	token, err := sm.tokenMgr.GetToken()
+	token, err := sm.tokenMgr.GetToken(ctx)
 	if err != nil {
+		sm.releaseOrFail(ctx, breaker, err)
 		return nil, fmt.Errorf("无法获取认证令牌: %w", err)
 	}
 
@@ -90,6 +114,12 @@ func (sm *SyncManager) doRequest(method, path string, params map[string]string)
 	// 发送请求
 	resp, err := sm.client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			// 调用方取消的请求不计为中心服务器故障
+			breaker.Release()
+			return nil, fmt.Errorf("请求已取消: %w", ctx.Err())
+		}
+		breaker.Failure(err)
 		sm.logger.Error("请求失败: %v", err)
 		// 对Authorization头进行脱敏处理
 		headers := req.Header.Clone()
@@ -100,6 +130,18 @@ func (sm *SyncManager) doRequest(method, path string, params map[string]string)
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
 
+	// 只有服务端错误和限流计为中心服务器故障，其余响应说明中心服务器可用
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		breaker.Failure(fmt.Errorf("状态码: %d", resp.StatusCode))
+	} else {
+		breaker.Success()
+	}
+
+	// 令牌被拒绝时使其失效，后续请求会重新认证
+	if resp.StatusCode == http.StatusUnauthorized {
+		sm.tokenMgr.Invalidate(token)
+	}
+
 	// 检查响应状态
 	if resp.StatusCode >= 400 {
 		// 确保响应体被正确关闭
@@ -126,6 +168,15 @@ func (sm *SyncManager) doRequest(method, path string, params map[string]string)
 	return resp, nil
 }
 
+// releaseOrFail 调用方已取消时归还熔断器的放行机会，否则记录一次失败
+func (sm *SyncManager) releaseOrFail(ctx context.Context, breaker *resilience.Breaker, err error) {
+	if ctx.Err() != nil {
+		breaker.Release()
+		return
+	}
+	breaker.Failure(err)
+}
+
 // decompress 使用zstd解压缩数据
 func decompress(data []byte) ([]byte, error) {
 	reader, err := zstd.NewReader(nil)
@@ -142,24 +193,16 @@ func decompress(data []byte) ([]byte, error) {
 	return decompressed, nil
 }
 
-// GetFileList 从中心服务器获取文件列表
-func (sm *SyncManager) GetFileList() ([]*File, error) {
-	// 获取最后修改时间
-	lastModified, err := sm.storage.GetLastModified()
-	if err != nil {
-		sm.logger.Warn("无法获取最后修改时间: %v", err)
-		lastModified = 0 // 如果无法获取最后修改时间，则获取所有文件
-	}
-
+// GetFileList 从中心服务器获取lastModified之后变化的文件列表，lastModified为0时获取完整列表
+func (sm *SyncManager) GetFileList(ctx context.Context, lastModified int64) ([]*File, error) {
 	// 设置查询参数
 	params := map[string]string{
 		"lastModified": fmt.Sprintf("%d", lastModified),
 	}
 
 	// 发送请求
-	resp, err := sm.doRequest("GET", "openbmclapi/files", params)
+	resp, err := sm.doRequest(ctx, "GET", "openbmclapi/files", params)
 	if err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("无法获取文件列表: %w", err))
 		return nil, fmt.Errorf("无法获取文件列表: %w", err)
 	}
 	defer func() {
@@ -177,22 +220,18 @@ func (sm *SyncManager) GetFileList() ([]*File, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("获取文件列表失败，状态码: %d", resp.StatusCode)
-		sm.errorMgr.RecordError(err)
-		return nil, err
+		return nil, fmt.Errorf("获取文件列表失败，状态码: %d", resp.StatusCode)
 	}
 
 	// 以二进制方式读取响应体
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("无法读取响应: %w", err))
 		return nil, fmt.Errorf("无法读取响应: %w", err)
 	}
 
 	// 使用zstd解压缩整个响应体
 	decompressed, err := decompress(body)
 	if err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("解压响应数据失败: %w", err))
 		return nil, fmt.Errorf("解压响应数据失败: %w", err)
 	}
 
@@ -202,18 +241,29 @@ func (sm *SyncManager) GetFileList() ([]*File, error) {
 	// 将解压后的数据转换为文件列表
 	files, err := convertBytesToFiles(decompressed)
 	if err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("解析文件列表失败: %w", err))
 		return nil, fmt.Errorf("解析文件列表失败: %w", err)
 	}
 
 	// 将文件列表写入JSON文件以便查看
 	sm.saveFileListAsJSON(files)
 
-	// 操作成功，重置错误计数
-	sm.errorMgr.ResetErrors()
+	// 记录文件大小，供重定向下载统计流量使用
+	for _, file := range files {
+		sm.fileSizes.Store(file.Hash, file.Size)
+	}
+
 	return files, nil
 }
 
+// FileSize 返回文件列表中记录的文件大小
+func (sm *SyncManager) FileSize(hash string) (int64, bool) {
+	size, ok := sm.fileSizes.Load(hash)
+	if !ok {
+		return 0, false
+	}
+	return size.(int64), true
+}
+
 // saveDecompressedData 将解压后的数据保存到本地文件
 func (sm *SyncManager) saveDecompressedData(data []byte) {
 	// 检查是否启用保存下载列表功能
@@ -331,61 +381,182 @@ func convertBytesToFiles(data []byte) ([]*File, error) {
 	return files, nil
 }
 
-// SyncFiles 同步文件
-func (sm *SyncManager) SyncFiles() error {
-	// 检查存储状态
+// SyncPlan 一次同步需要处理的文件
+type SyncPlan struct {
+	// Files 中心服务器返回的文件列表
+	Files []*File
+	// Missing 存储中缺失、需要下载的文件
+	Missing []*storage.FileInfo
+	// LastModified 请求文件列表时使用的水位
+	LastModified int64
+	// Full 是否为完整的文件列表
+	Full bool
+}
+
+// SyncResult 一次同步的下载结果
+type SyncResult struct {
+	Total  int
+	Failed int
+}
 
-	ready, err := sm.storage.Check()
+// SyncFiles 同步文件，ctx取消后停止启动新的下载
+func (sm *SyncManager) SyncFiles(ctx context.Context) error {
+	plan, err := sm.Plan(ctx, false)
 	if err != nil {
-		return fmt.Errorf("存储检查失败: %w", err)
+		return err
 	}
-	if !ready {
-		return fmt.Errorf("存储未就绪")
+
+	_, err = sm.Apply(ctx, plan)
+	return err
+}
+
+// Plan 获取文件列表并计算缺失的文件，不执行下载。full为true时忽略水位获取完整列表
+func (sm *SyncManager) Plan(ctx context.Context, full bool) (*SyncPlan, error) {
+	// 检查存储状态，结果同时用于探测存储是否已恢复
+	storageBreaker := sm.policy.Breaker(DependencyStorage)
+	err := storageBreaker.Do(func() error {
+		ready, err := sm.storage.Check(ctx)
+		if err != nil {
+			return fmt.Errorf("存储检查失败: %w", err)
+		}
+		if !ready {
+			return fmt.Errorf("存储未就绪")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取上次成功同步的水位，状态缺失或损坏时获取完整列表
+	var lastModified int64
+	if !full {
+		lastModified, err = sm.state.Load()
+		if err != nil {
+			sm.logger.Warn("无法读取同步水位，将获取完整文件列表: %v", err)
+			lastModified = 0
+		}
 	}
 
 	// 获取文件列表
-	files, err := sm.GetFileList()
+	files, err := sm.GetFileList(ctx, lastModified)
 	if err != nil {
-		return fmt.Errorf("无法获取文件列表: %w", err)
+		return nil, fmt.Errorf("无法获取文件列表: %w", err)
 	}
 
 	// 检查是否没有文件需要同步
 	if len(files) == 0 {
-		sm.logger.Info("没有文件需要同步")
-		sm.errorMgr.ResetErrors()
-		return nil
+		return &SyncPlan{LastModified: lastModified, Full: lastModified == 0}, nil
 	}
 
-	// 转换文件格式
-	storageFiles := convertFiles(files)
+	// 转换文件格式并获取缺失的文件
+	var missingFiles []*storage.FileInfo
+	err = storageBreaker.Do(func() error {
+		missingFiles, err = sm.storage.GetMissingFiles(ctx, convertFiles(files))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法检查缺失的文件: %w", err)
+	}
 
-	// 获取缺失的文件
+	return &SyncPlan{
+		Files:        files,
+		Missing:      missingFiles,
+		LastModified: lastModified,
+		Full:         lastModified == 0,
+	}, nil
+}
 
-	missingFiles, err := sm.storage.GetMissingFiles(storageFiles)
+// Apply 下载计划中缺失的文件，ctx取消后不再启动新的下载，已开始的下载随之中止
+func (sm *SyncManager) Apply(ctx context.Context, plan *SyncPlan) (*SyncResult, error) {
+	result := &SyncResult{Total: len(plan.Missing)}
 
-	if err != nil {
-		return fmt.Errorf("无法检查缺失的文件: %w", err)
+	syncFilesGauge.Set(float64(len(plan.Missing)))
+	syncDoneGauge.Set(0)
+
+	// 检查是否没有文件需要同步
+	if len(plan.Missing) == 0 {
+		sm.logger.Info("没有文件需要同步")
+		sm.saveWatermark(plan)
+		syncRuns.WithLabelValues("success").Inc()
+		syncLastSuccess.SetToCurrentTime()
+		return result, nil
 	}
 
 	// 使用并行下载文件，控制并发度
-	failedCount := sm.syncFiles(missingFiles)
+	result.Failed = sm.syncFiles(ctx, plan.Missing)
 
 	// 显示最终结果
 	sm.logger.Info("文件同步完成: 成功 %d, 失败 %d, 总计 %d",
-		len(missingFiles)-failedCount, failedCount, len(missingFiles))
+		result.Total-result.Failed, result.Failed, result.Total)
 
-	if failedCount > 0 {
-		return fmt.Errorf("有 %d 个文件下载失败", failedCount)
+	if err := ctx.Err(); err != nil {
+		syncRuns.WithLabelValues("cancelled").Inc()
+		return result, fmt.Errorf("同步已取消: %w", err)
 	}
 
-	// 同步成功，重置错误计数
-	sm.errorMgr.ResetErrors()
-	sm.logger.Info("文件同步完成，共处理 %d 个文件", len(files))
-	return nil
+	if result.Failed > 0 {
+		syncRuns.WithLabelValues("failure").Inc()
+		return result, fmt.Errorf("有 %d 个文件下载失败", result.Failed)
+	}
+
+	// 同步成功，记录水位
+	sm.saveWatermark(plan)
+	syncRuns.WithLabelValues("success").Inc()
+	syncLastSuccess.SetToCurrentTime()
+	sm.logger.Info("文件同步完成，共处理 %d 个文件", len(plan.Files))
+	return result, nil
+}
+
+// saveWatermark 在完全成功的同步后保存文件列表中的最大mtime
+func (sm *SyncManager) saveWatermark(plan *SyncPlan) {
+	watermark := plan.LastModified
+	for _, file := range plan.Files {
+		if file.MTime > watermark {
+			watermark = file.MTime
+		}
+	}
+
+	if watermark == plan.LastModified {
+		return
+	}
+
+	if err := sm.state.Save(watermark); err != nil {
+		sm.logger.Warn("无法保存同步水位: %v", err)
+		return
+	}
+	sm.logger.Debug("同步水位已更新: %d -> %d", plan.LastModified, watermark)
+}
+
+// CollectGarbage 根据完整的文件列表清理存储中已被移除的文件
+func (sm *SyncManager) CollectGarbage(ctx context.Context, plan *SyncPlan, opts storage.GCOptions) (*storage.GCResult, error) {
+	if !plan.Full {
+		return nil, fmt.Errorf("垃圾回收需要完整的文件列表")
+	}
+	// 空列表很可能意味着中心服务器返回异常，不能据此清空缓存
+	if len(plan.Files) == 0 {
+		return nil, fmt.Errorf("文件列表为空，跳过垃圾回收")
+	}
+
+	return sm.storage.GC(ctx, convertFiles(plan.Files), opts)
 }
 
-// syncFiles 并行下载缺失的文件
-func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
+// MissingFiles 根据完整文件列表返回存储中缺失的文件
+func (sm *SyncManager) MissingFiles(ctx context.Context) ([]*storage.FileInfo, error) {
+	plan, err := sm.Plan(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Missing, nil
+}
+
+// ResetWatermark 清除同步水位，下一次同步将获取完整文件列表
+func (sm *SyncManager) ResetWatermark() error {
+	return sm.state.Reset()
+}
+
+// syncFiles 并行下载缺失的文件，返回失败数。ctx取消后不再启动新的下载
+func (sm *SyncManager) syncFiles(ctx context.Context, missingFiles []*storage.FileInfo) int {
 	maxConcurrent := sm.config.MaxConcurrency
 	startInterval := sm.config.StartIntervalMs
 
@@ -414,16 +585,29 @@ func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
 
 	// 显示初始进度信息
 	sm.logger.Info("开始同步文件，总数: %d", totalFiles)
+	progressStep := int64(totalFiles / 10)
+	if progressStep == 0 {
+		progressStep = 1
+	}
 
 	// 使用重试机制下载每个文件
+	launched := 0
 	for i, file := range missingFiles {
-		// 控制启动间隔
-		if i > 0 {
-			time.Sleep(time.Duration(startInterval) * time.Millisecond)
+		// 控制启动间隔，取消后不再启动新的下载
+		if i > 0 && startInterval > 0 {
+			select {
+			case <-time.After(time.Duration(startInterval) * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
 		}
+		launched++
 
 		// 增加等待组计数
 		wg.Add(1)
+		syncQueueDepth.Inc()
 
 		// 启动下载协程
 		go func(f *storage.FileInfo) {
@@ -431,14 +615,12 @@ func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
 			defer func() {
 				// 增加已完成计数
 				current := atomic.AddInt64(&downloadedCount, 1)
+				syncDoneGauge.Set(float64(current))
 
-				// 计算并显示进度（在同一行刷新）
-				progress := float64(current) / float64(totalFiles) * 100
-				fmt.Printf("\r[INFO] 同步进度: %d/%d (%.2f%%)", current, totalFiles, progress)
-
-				// 如果已完成所有文件，输出换行符
-				if current >= int64(totalFiles) {
-					fmt.Println()
+				// 大约每完成10%输出一次进度
+				if current%progressStep == 0 || current >= int64(totalFiles) {
+					progress := float64(current) / float64(totalFiles) * 100
+					sm.logger.With("done", current, "total", totalFiles).Info("同步进度: %d/%d (%.2f%%)", current, totalFiles, progress)
 				}
 
 				// 确保从信号量中释放资源
@@ -451,11 +633,18 @@ func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
 
 			// 获取信号量
 			semaphore <- struct{}{}
+			syncQueueDepth.Dec()
+			syncInFlight.Inc()
+			defer syncInFlight.Dec()
 
 			// 下载文件，支持重试
-			if err := sm.downloadFileWithRetry(f); err != nil {
+			if err := sm.downloadFileWithRetry(ctx, f); err != nil {
+				syncDownloads.WithLabelValues("failure").Inc()
 				errChan <- err
+				return
 			}
+			syncDownloads.WithLabelValues("success").Inc()
+			syncDownloadBytes.Add(float64(f.Size))
 		}(file)
 	}
 
@@ -466,7 +655,8 @@ func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
 	close(errChan)
 
 	// 统计失败数量
-	failedCount := 0
+	// 因取消而未启动的文件同样计为失败
+	failedCount := totalFiles - launched
 	for range errChan {
 		failedCount++
 	}
@@ -477,36 +667,44 @@ func (sm *SyncManager) syncFiles(missingFiles []*storage.FileInfo) int {
 	return failedCount
 }
 
-// downloadFileWithRetry 下载单个文件，支持重试机制
-func (sm *SyncManager) downloadFileWithRetry(file *storage.FileInfo) error {
+// downloadFileWithRetry 下载单个文件，失败时按退避策略重试，依赖熔断时不再重试
+func (sm *SyncManager) downloadFileWithRetry(ctx context.Context, file *storage.FileInfo) error {
 	var lastErr error
 	maxRetries := 3
 
 	for i := 0; i < maxRetries; i++ {
-		if err := sm.downloadFile(file); err != nil {
-			lastErr = err
-			sm.logger.Warn("下载文件 %s 失败 (%d/%d): %v", file.Hash, i+1, maxRetries, err)
+		err := sm.downloadFile(ctx, file)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if errors.Is(err, resilience.ErrBreakerOpen) || ctx.Err() != nil {
+			break
+		}
 
-			// 等待一段时间再重试
-			if i < maxRetries-1 {
-				time.Sleep(time.Duration(i+1) * time.Second)
-			}
-			continue
+		sm.logger.Warn("下载文件 %s 失败 (%d/%d): %v", file.Hash, i+1, maxRetries, err)
+
+		// 等待一段时间再重试
+		if i < maxRetries-1 && !sm.policy.Backoff.Sleep(i, ctx.Done()) {
+			break
 		}
-		return nil
 	}
 
-	return fmt.Errorf("下载文件 %s 失败，已重试%d次: %w", file.Hash, maxRetries, lastErr)
+	return fmt.Errorf("下载文件 %s 失败: %w", file.Hash, lastErr)
 }
 
 // downloadFile 下载单个文件
-func (sm *SyncManager) downloadFile(file *storage.FileInfo) error {
-	// 创建请求路径
+func (sm *SyncManager) downloadFile(ctx context.Context, file *storage.FileInfo) error {
+	storageBreaker := sm.policy.Breaker(DependencyStorage)
+	if err := storageBreaker.Allow(); err != nil {
+		return err
+	}
 
 	// 发送请求
-	resp, err := sm.doRequest("GET", file.Path[1:], nil)
+	resp, err := sm.doRequest(ctx, "GET", file.Path[1:], nil)
 	if err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("无法下载文件 %s: %w", file.Hash, err))
+		// 没有访问存储，归还探测机会
+		storageBreaker.Release()
 		return fmt.Errorf("无法下载文件 %s: %w", file.Hash, err)
 	}
 
@@ -517,14 +715,33 @@ func (sm *SyncManager) downloadFile(file *storage.FileInfo) error {
 		}
 	}()
 
+	// 边下载边校验哈希和大小，校验失败时存储层不会保留该文件
+	verifier, err := newVerifyReader(resp.Body, file.Hash, file.Size)
+	if err != nil {
+		storageBreaker.Release()
+		return fmt.Errorf("无法校验文件 %s: %w", file.Hash, err)
+	}
+
 	// 保存文件
-	if err := sm.storage.Put(file.Hash, resp.Body); err != nil {
-		sm.errorMgr.RecordError(fmt.Errorf("无法保存文件 %s: %w", file.Hash, err))
+	if err := sm.storage.Put(ctx, file.Hash, verifier); err != nil {
+		switch {
+		case ctx.Err() != nil:
+			// 调用方取消，不计入任何依赖的故障
+			storageBreaker.Release()
+		case verifier.sourceErr != nil:
+			// 传输中断属于中心服务器一侧的故障
+			storageBreaker.Release()
+			sm.policy.Breaker(DependencyCenter).Failure(verifier.sourceErr)
+		case errors.Is(err, ErrHashMismatch), errors.Is(err, ErrSizeMismatch):
+			// 内容校验失败与存储无关
+			storageBreaker.Release()
+		default:
+			storageBreaker.Failure(err)
+		}
 		return fmt.Errorf("无法保存文件 %s: %w", file.Hash, err)
 	}
 
-	// 操作成功，重置错误计数
-	sm.errorMgr.ResetErrors()
+	storageBreaker.Success()
 	return nil
 }
 
@@ -540,44 +757,3 @@ func convertFiles(files []*File) []*storage.FileInfo {
 	}
 	return result
 }
-
-// ErrorRetryManager 错误重试管理器
-type ErrorRetryManager struct {
-	maxRetries    int
-	errorCount    int
-	lastErrorTime time.Time
-	logger        *logger.Logger
-}
-
-// NewErrorRetryManager 创建新的错误重试管理器
-func NewErrorRetryManager(maxRetries int, logger *logger.Logger) *ErrorRetryManager {
-	return &ErrorRetryManager{
-		maxRetries: maxRetries,
-		logger:     logger,
-	}
-}
-
-// RecordError 记录错误，如果错误次数超过最大重试次数则关闭进程
-func (erm *ErrorRetryManager) RecordError(err error) {
-	erm.errorCount++
-	erm.lastErrorTime = time.Now()
-
-	erm.logger.Error("发生错误 (%d/%d): %v", erm.errorCount, erm.maxRetries, err)
-
-	if erm.errorCount > erm.maxRetries {
-		erm.logger.Fatal("错误次数超过最大重试次数 (%d)，正在关闭进程", erm.maxRetries)
-	}
-}
-
-// ResetErrors 重置错误计数
-func (erm *ErrorRetryManager) ResetErrors() {
-	if erm.errorCount > 0 {
-		erm.logger.Info("重置错误计数: %d -> 0", erm.errorCount)
-		erm.errorCount = 0
-	}
-}
-
-// GetErrorCount 获取当前错误计数
-func (erm *ErrorRetryManager) GetErrorCount() int {
-	return erm.errorCount
-}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrHashMismatch 下载内容的哈希与文件列表不一致
+	ErrHashMismatch = errors.New("文件哈希不匹配")
+	// ErrSizeMismatch 下载内容的大小与文件列表不一致
+	ErrSizeMismatch = errors.New("文件大小不匹配")
+)
+
+// verifyReader 在读取数据的同时计算哈希，读到末尾时校验哈希和大小。
+// 校验失败时Read返回错误而不是io.EOF，使存储层放弃写入
+type verifyReader struct {
+	reader       io.Reader
+	hasher       hash.Hash
+	expectedHash string
+	expectedSize int64
+	size         int64
+	// sourceErr 读取下载数据时发生的传输错误，用于区分网络故障与存储故障
+	sourceErr error
+}
+
+// newHasher 根据哈希长度选择算法：32位为MD5，40位为SHA-1
+func newHasher(expectedHash string) (hash.Hash, error) {
+	switch len(expectedHash) {
+	case md5.Size * 2:
+		return md5.New(), nil
+	case sha1.Size * 2:
+		return sha1.New(), nil
+	default:
+		return nil, fmt.Errorf("无法识别的哈希长度: %d", len(expectedHash))
+	}
+}
+
+// newVerifyReader 创建校验读取器，expectedSize小于0时不校验大小
+func newVerifyReader(reader io.Reader, expectedHash string, expectedSize int64) (*verifyReader, error) {
+	hasher, err := newHasher(expectedHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyReader{
+		reader:       reader,
+		hasher:       hasher,
+		expectedHash: strings.ToLower(expectedHash),
+		expectedSize: expectedSize,
+	}, nil
+}
+
+// Read 实现io.Reader接口
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	if err != nil && err != io.EOF {
+		v.sourceErr = err
+	}
+	if n > 0 {
+		v.hasher.Write(p[:n])
+		v.size += int64(n)
+
+		// 超出预期大小时立即终止，避免继续接收多余的数据
+		if v.expectedSize >= 0 && v.size > v.expectedSize {
+			return n, fmt.Errorf("%w: 期望 %d 字节, 已收到超过 %d 字节", ErrSizeMismatch, v.expectedSize, v.size)
+		}
+	}
+
+	if err == io.EOF {
+		if verifyErr := v.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+
+	return n, err
+}
+
+// verify 校验已读取数据的大小和哈希
+func (v *verifyReader) verify() error {
+	if v.expectedSize >= 0 && v.size != v.expectedSize {
+		return fmt.Errorf("%w: 期望 %d 字节, 实际 %d 字节", ErrSizeMismatch, v.expectedSize, v.size)
+	}
+
+	actual := hex.EncodeToString(v.hasher.Sum(nil))
+	if actual != v.expectedHash {
+		return fmt.Errorf("%w: 期望 %s, 实际 %s", ErrHashMismatch, v.expectedHash, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/storage"
+)
+
+const (
+	helloSHA1 = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	helloMD5  = "5d41402abc4b2a76b9719d911017c592"
+)
+
+func TestVerifyReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		size    int64
+		wantErr error
+	}{
+		{"SHA-1", helloSHA1, 5, nil},
+		{"MD5", helloMD5, 5, nil},
+		{"大写哈希", strings.ToUpper(helloSHA1), 5, nil},
+		{"不校验大小", helloSHA1, -1, nil},
+		{"哈希不匹配", "aaf4c61ddcc5e8a2dabede0f3b482cd9aea94340", 5, ErrHashMismatch},
+		{"内容过短", helloSHA1, 6, ErrSizeMismatch},
+		{"内容过长", helloSHA1, 4, ErrSizeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := newVerifyReader(strings.NewReader("hello"), tt.hash, tt.size)
+			if err != nil {
+				t.Fatalf("newVerifyReader: %v", err)
+			}
+			_, err = io.ReadAll(reader)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("错误 = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyReaderUnknownHash(t *testing.T) {
+	if _, err := newVerifyReader(strings.NewReader("hello"), "abc", 5); err == nil {
+		t.Error("无法识别的哈希长度应当返回错误")
+	}
+}
+
+func TestVerifyReaderEmptyFile(t *testing.T) {
+	// 空内容的SHA-1
+	reader, err := newVerifyReader(strings.NewReader(""), "da39a3ee5e6b4b0d3255bfef95601890afd80709", 0)
+	if err != nil {
+		t.Fatalf("newVerifyReader: %v", err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("空文件校验失败: %v", err)
+	}
+}
+
+// endlessReader 不断返回数据，模拟响应体超出文件列表中的大小
+type endlessReader struct {
+	read int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestVerifyReaderStopsOnOversize(t *testing.T) {
+	source := &endlessReader{}
+	reader, err := newVerifyReader(source, helloSHA1, 5)
+	if err != nil {
+		t.Fatalf("newVerifyReader: %v", err)
+	}
+
+	if _, err := io.Copy(io.Discard, reader); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("错误 = %v, want %v", err, ErrSizeMismatch)
+	}
+	// 超出大小后立即终止，不再继续读取
+	if source.read > 64*1024 {
+		t.Errorf("超出大小后仍读取了 %d 字节", source.read)
+	}
+}
+
+func TestVerifyReaderSourceError(t *testing.T) {
+	errReset := errors.New("connection reset by peer")
+	source := io.MultiReader(strings.NewReader("hel"), iotest.ErrReader(errReset))
+	reader, err := newVerifyReader(source, helloSHA1, 5)
+	if err != nil {
+		t.Fatalf("newVerifyReader: %v", err)
+	}
+
+	// 传输错误原样返回，不当作内容校验失败
+	_, err = io.ReadAll(reader)
+	if !errors.Is(err, errReset) || errors.Is(err, ErrSizeMismatch) || errors.Is(err, ErrHashMismatch) {
+		t.Errorf("错误 = %v, want %v", err, errReset)
+	}
+	if !errors.Is(reader.sourceErr, errReset) {
+		t.Errorf("sourceErr = %v, want %v", reader.sourceErr, errReset)
+	}
+}
+
+// TestHashMismatchLeavesNothing 校验失败的下载不应在文件存储中留下任何文件
+func TestHashMismatchLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	fs := storage.NewFileStorage(dir, logger.New(false))
+	ctx := context.Background()
+	if err := fs.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	// 内容为"hello"，但文件列表中的哈希属于其他内容
+	wrongHash := "0a4d55a8d778e5022fab701977c5d840bbc486d0"
+	reader, err := newVerifyReader(strings.NewReader("hello"), wrongHash, 5)
+	if err != nil {
+		t.Fatalf("newVerifyReader: %v", err)
+	}
+	if err := fs.Put(ctx, wrongHash, reader); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Put 错误 = %v, want %v", err, ErrHashMismatch)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, wrongHash[:2]))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("哈希不匹配后遗留了 %d 个文件", len(entries))
+	}
+}
@@ -0,0 +1,31 @@
+package token
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// tokenRequests 按类型（fetch为挑战认证，refresh为续期）和结果统计的令牌请求次数
+var tokenRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "openbmclapi",
+	Subsystem: "token",
+	Name:      "requests_total",
+	Help:      "Token fetches and refreshes by result.",
+}, []string{"kind", "result"})
+
+// observeToken 记录一次令牌请求的结果
+func observeToken(kind string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "error"
+	}
+	tokenRequests.WithLabelValues(kind, result).Inc()
+}
+
+// tokenExpiry 当前令牌的过期时间，没有有效令牌时为0
+var tokenExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "openbmclapi",
+	Subsystem: "token",
+	Name:      "expiry_timestamp_seconds",
+	Help:      "Unix time at which the current token expires, 0 if none.",
+})
@@ -2,6 +2,7 @@ package token
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -11,16 +12,64 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
+)
+
+const (
+	// requestTimeout 单次获取或续期令牌的超时时间
+	requestTimeout = 30 * time.Second
+	// expiryMargin 距离过期不足该时间的令牌视为已过期，避免令牌在请求途中失效
+	expiryMargin = 30 * time.Second
+	// defaultTTL 中心服务器未返回有效期时假定的有效期
+	defaultTTL = time.Hour
 )
 
-// TokenManager 管理与中心服务器的认证令牌
+// minRefreshDelay 两次续期之间的最短间隔
+var minRefreshDelay = 10 * time.Second
+
+// TokenManager 管理与中心服务器的认证令牌。令牌在有效期过半时由后台协程续期，
+// 同一时间最多只有一个获取或续期请求，失败时按退避策略重试
 type TokenManager struct {
 	clusterID     string
 	clusterSecret string
-	token         string
-	mu            sync.RWMutex
 	client        *http.Client
 	serverURL     string
+	logger        *logger.Logger
+	backoff       resilience.Backoff
+
+	mu          sync.Mutex
+	token       string
+	issuedAt    time.Time
+	expiresAt   time.Time
+	lastRefresh time.Time
+	failures    int
+	lastErr     error
+	// call 正在进行的获取或续期请求
+	call *tokenCall
+
+	refreshOnce sync.Once
+
+	// ctx 在Close时取消，用于后台的令牌获取与续期
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// tokenCall 一次正在进行的令牌请求，并发的调用方共享其结果
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// State 令牌的可观测状态，用于健康检查
+type State struct {
+	Valid       bool      `json:"valid"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	LastRefresh time.Time `json:"lastRefresh"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"lastError,omitempty"`
 }
 
 // ChallengeResponse 挑战认证响应结构
@@ -31,173 +80,311 @@ type ChallengeResponse struct {
 // TokenResponse 令牌响应结构
 type TokenResponse struct {
 	Token string `json:"token"`
-	TTL   int64  `json:"ttl"`
+	// TTL 令牌有效期(毫秒)
+	TTL int64 `json:"ttl"`
 }
 
 // NewTokenManager 创建新的令牌管理器
-func NewTokenManager(clusterID, clusterSecret, serverURL string) *TokenManager {
+func NewTokenManager(clusterID, clusterSecret, serverURL string, logger *logger.Logger) *TokenManager {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TokenManager{
 		clusterID:     clusterID,
 		clusterSecret: clusterSecret,
 		client:        &http.Client{},
 		serverURL:     serverURL,
+		logger:        logger,
+		backoff:       resilience.DefaultBackoff,
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
-// GetToken 获取当前有效的令牌
-func (tm *TokenManager) GetToken() (string, error) {
-	tm.mu.RLock()
-	token := tm.token
-	tm.mu.RUnlock()
+// GetToken 获取当前有效的令牌，需要向中心服务器请求时遵循ctx的取消和超时
+func (tm *TokenManager) GetToken(ctx context.Context) (string, error) {
+	return tm.obtain(ctx, false)
+}
+
+// Invalidate 使令牌失效并立即在后台重新进行挑战认证。
+// 仅当token仍是当前令牌时生效，避免过时请求的401使刚获取的新令牌失效
+func (tm *TokenManager) Invalidate(token string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
 
-	if token != "" {
+	if token == "" || token != tm.token {
+		return
+	}
+	tm.token = ""
+	tm.expiresAt = time.Time{}
+	tokenExpiry.Set(0)
+	tm.logger.Warn("认证令牌被中心服务器拒绝，将重新认证")
+	tm.startLocked()
+}
+
+// State 返回令牌的当前状态
+func (tm *TokenManager) State() State {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	state := State{
+		Valid:       tm.validLocked(),
+		ExpiresAt:   tm.expiresAt,
+		LastRefresh: tm.lastRefresh,
+		Failures:    tm.failures,
+	}
+	if tm.lastErr != nil {
+		state.LastError = tm.lastErr.Error()
+	}
+	return state
+}
+
+// Close 停止令牌的定期续期，并中止正在进行的请求
+func (tm *TokenManager) Close() {
+	tm.cancel()
+}
+
+// validLocked 返回当前令牌是否仍在有效期内，调用方需持有mu
+func (tm *TokenManager) validLocked() bool {
+	// 有效期很短的令牌按其四分之一预留余量
+	margin := expiryMargin
+	if quarter := tm.expiresAt.Sub(tm.issuedAt) / 4; quarter < margin {
+		margin = quarter
+	}
+	return tm.token != "" && time.Now().Before(tm.expiresAt.Add(-margin))
+}
+
+// obtain 返回有效的令牌。force为false且当前令牌有效时直接返回，否则加入或发起一次请求：
+// 当前令牌有效时续期，否则重新进行挑战认证
+func (tm *TokenManager) obtain(ctx context.Context, force bool) (string, error) {
+	tm.mu.Lock()
+	if !force && tm.validLocked() {
+		token := tm.token
+		tm.mu.Unlock()
 		return token, nil
 	}
 
-	// 获取新令牌
-	return tm.fetchToken()
+	call := tm.startLocked()
+	tm.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// startLocked 返回正在进行的请求，没有时发起一次新的请求，调用方需持有mu。
+// 请求在独立的协程中执行，单个调用方取消不影响其他等待者
+func (tm *TokenManager) startLocked() *tokenCall {
+	if tm.call != nil {
+		return tm.call
+	}
+
+	call := &tokenCall{done: make(chan struct{})}
+	tm.call = call
+	current := ""
+	if tm.validLocked() {
+		current = tm.token
+	}
+	go tm.execute(call, current)
+	return call
+}
+
+// execute 执行一次令牌请求并保存结果。current不为空时先尝试续期，续期失败则重新进行挑战认证
+func (tm *TokenManager) execute(call *tokenCall, current string) {
+	ctx, cancel := context.WithTimeout(tm.ctx, requestTimeout)
+	defer cancel()
+
+	var resp *TokenResponse
+	var err error
+	if current != "" {
+		resp, err = tm.refreshToken(ctx, current)
+		observeToken("refresh", err == nil)
+		if err != nil && ctx.Err() == nil {
+			tm.logger.Warn("续期令牌失败，将重新认证: %v", err)
+		}
+	}
+	if current == "" || (err != nil && ctx.Err() == nil) {
+		resp, err = tm.fetchToken(ctx)
+		observeToken("fetch", err == nil)
+	}
+
+	tm.mu.Lock()
+	tm.call = nil
+	if err != nil {
+		tm.failures++
+		tm.lastErr = err
+	} else {
+		ttl := time.Duration(resp.TTL) * time.Millisecond
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+		now := time.Now()
+		tm.token = resp.Token
+		tm.issuedAt = now
+		tm.expiresAt = now.Add(ttl)
+		tm.lastRefresh = now
+		tm.failures = 0
+		tm.lastErr = nil
+		tokenExpiry.Set(float64(tm.expiresAt.Unix()))
+		call.token = resp.Token
+	}
+	tm.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+
+	if err == nil {
+		tm.refreshOnce.Do(func() {
+			go tm.refreshLoop()
+		})
+	}
 }
 
-// fetchToken 从中心服务器获取新令牌
-func (tm *TokenManager) fetchToken() (string, error) {
+// refreshLoop 在令牌有效期过半时续期，失败时按退避策略重试，直到Close被调用
+func (tm *TokenManager) refreshLoop() {
+	attempt := 0
+	for {
+		timer := time.NewTimer(tm.nextRefresh(attempt))
+		select {
+		case <-tm.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := tm.obtain(tm.ctx, true); err != nil {
+			if tm.ctx.Err() != nil {
+				return
+			}
+			tm.logger.Error("刷新令牌失败 (第%d次): %v", attempt+1, err)
+			attempt++
+			continue
+		}
+		attempt = 0
+	}
+}
+
+// nextRefresh 返回距离下一次续期的等待时间，attempt为连续失败的次数
+func (tm *TokenManager) nextRefresh(attempt int) time.Duration {
+	if attempt > 0 {
+		return tm.backoff.Delay(attempt - 1)
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	// 在有效期过半时续期
+	delay := time.Until(tm.issuedAt.Add(tm.expiresAt.Sub(tm.issuedAt) / 2))
+	if delay < minRefreshDelay {
+		delay = minRefreshDelay
+	}
+	return delay
+}
+
+// fetchToken 通过挑战认证从中心服务器获取新令牌
+func (tm *TokenManager) fetchToken(ctx context.Context) (*TokenResponse, error) {
 	// 请求挑战
 	challengeURL := fmt.Sprintf("%s/openbmclapi-agent/challenge?clusterId=%s", tm.serverURL, tm.clusterID)
-	resp, err := tm.client.Get(challengeURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, challengeURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("无法获取挑战: %w", err)
+		return nil, fmt.Errorf("无法创建挑战请求: %w", err)
+	}
+	resp, err := tm.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取挑战: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("获取挑战失败，状态码: %d", resp.StatusCode)
+		return nil, fmt.Errorf("获取挑战失败，状态码: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("无法读取挑战响应: %w", err)
+		return nil, fmt.Errorf("无法读取挑战响应: %w", err)
 	}
 
 	var challengeResp ChallengeResponse
 	if err := json.Unmarshal(body, &challengeResp); err != nil {
-		return "", fmt.Errorf("无法解析挑战响应: %w", err)
+		return nil, fmt.Errorf("无法解析挑战响应: %w", err)
 	}
 
 	// 签名挑战
 	signature := tm.signChallenge(challengeResp.Challenge)
 
 	// 请求令牌
-	tokenURL := fmt.Sprintf("%s/openbmclapi-agent/token", tm.serverURL)
 	tokenReq := map[string]interface{}{
 		"clusterId": tm.clusterID,
 		"challenge": challengeResp.Challenge,
 		"signature": signature,
 	}
 
-	tokenReqBytes, err := json.Marshal(tokenReq)
+	tokenResp, err := tm.postToken(ctx, tokenReq)
 	if err != nil {
-		return "", fmt.Errorf("无法序列化令牌请求: %w", err)
+		return nil, fmt.Errorf("无法获取令牌: %w", err)
 	}
+	return tokenResp, nil
+}
 
-	tokenResp, err := tm.client.Post(tokenURL, "application/json", bytes.NewBuffer(tokenReqBytes))
-	if err != nil {
-		return "", fmt.Errorf("无法获取令牌: %w", err)
-	}
-	defer tokenResp.Body.Close()
-
-	// 修改状态码检查：201才是正确的状态码
-	if tokenResp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("获取令牌失败，状态码: %d", tokenResp.StatusCode)
+// refreshToken 使用当前令牌换取新令牌
+func (tm *TokenManager) refreshToken(ctx context.Context, current string) (*TokenResponse, error) {
+	tokenReq := map[string]interface{}{
+		"clusterId": tm.clusterID,
+		"token":     current,
 	}
 
-	tokenBody, err := io.ReadAll(tokenResp.Body)
+	tokenResp, err := tm.postToken(ctx, tokenReq)
 	if err != nil {
-		return "", fmt.Errorf("无法读取令牌响应: %w", err)
+		return nil, fmt.Errorf("无法刷新令牌: %w", err)
 	}
-
-	var tokenRespData TokenResponse
-	if err := json.Unmarshal(tokenBody, &tokenRespData); err != nil {
-		return "", fmt.Errorf("无法解析令牌响应: %w", err)
-	}
-	// 保存令牌
-	tm.mu.Lock()
-	tm.token = tokenRespData.Token
-	tm.mu.Unlock()
-
-	// 安排令牌刷新
-	go tm.scheduleRefresh(tokenRespData.TTL)
-
-	return tokenRespData.Token, nil
-}
-
-// signChallenge 使用HMAC-SHA256签名挑战
-func (tm *TokenManager) signChallenge(challenge string) string {
-	key := []byte(tm.clusterSecret)
-	h := hmac.New(sha256.New, key)
-	h.Write([]byte(challenge))
-	return hex.EncodeToString(h.Sum(nil))
+	return tokenResp, nil
 }
 
-// scheduleRefresh 安排令牌刷新
-func (tm *TokenManager) scheduleRefresh(ttl int64) {
-	// 在令牌过期前10分钟刷新，或者在TTL的一半时间刷新（取较大值）
-	refreshTime := ttl / 2
-	if refreshTime < 600 { // 最少10分钟
-		refreshTime = 600
+// postToken 向中心服务器的令牌接口提交请求并解析响应
+func (tm *TokenManager) postToken(ctx context.Context, payload map[string]interface{}) (*TokenResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("无法序列化令牌请求: %w", err)
 	}
 
-	time.Sleep(time.Duration(refreshTime) * time.Second)
-	tm.refreshToken()
-}
-
-// refreshToken 刷新令牌
-func (tm *TokenManager) refreshToken() {
-	tm.mu.RLock()
-	currentToken := tm.token
-	tm.mu.RUnlock()
-
-	// 使用当前令牌获取新令牌
 	tokenURL := fmt.Sprintf("%s/openbmclapi-agent/token", tm.serverURL)
-	tokenReq := map[string]interface{}{
-		"clusterId": tm.clusterID,
-		"token":     currentToken,
-	}
-
-	tokenReqBytes, err := json.Marshal(tokenReq)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, bytes.NewReader(body))
 	if err != nil {
-		fmt.Printf("无法序列化令牌刷新请求: %v\n", err)
-		return
+		return nil, fmt.Errorf("无法创建令牌请求: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	tokenResp, err := tm.client.Post(tokenURL, "application/json", bytes.NewBuffer(tokenReqBytes))
+	resp, err := tm.client.Do(req)
 	if err != nil {
-		fmt.Printf("无法刷新令牌: %v\n", err)
-		return
+		return nil, err
 	}
-	defer tokenResp.Body.Close()
+	defer resp.Body.Close()
 
-	// 修改状态码检查：201才是正确的状态码
-	if tokenResp.StatusCode != http.StatusCreated {
-		fmt.Printf("刷新令牌失败，状态码: %d\n", tokenResp.StatusCode)
-		return
+	// 201才是正确的状态码
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("状态码: %d", resp.StatusCode)
 	}
 
-	tokenBody, err := io.ReadAll(tokenResp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("无法读取令牌刷新响应: %v\n", err)
-		return
+		return nil, fmt.Errorf("无法读取令牌响应: %w", err)
 	}
 
-	var tokenRespData TokenResponse
-	if err := json.Unmarshal(tokenBody, &tokenRespData); err != nil {
-		fmt.Printf("无法解析令牌刷新响应: %v\n", err)
-		return
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(respBody, &tokenResp); err != nil {
+		return nil, fmt.Errorf("无法解析令牌响应: %w", err)
 	}
+	if tokenResp.Token == "" {
+		return nil, fmt.Errorf("令牌响应中没有令牌")
+	}
+	return &tokenResp, nil
+}
 
-	// 更新令牌
-	tm.mu.Lock()
-	tm.token = tokenRespData.Token
-	tm.mu.Unlock()
-
-	// 安排下次刷新
-	go tm.scheduleRefresh(tokenRespData.TTL)
+// signChallenge 使用HMAC-SHA256签名挑战
+func (tm *TokenManager) signChallenge(challenge string) string {
+	key := []byte(tm.clusterSecret)
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(challenge))
+	return hex.EncodeToString(h.Sum(nil))
 }
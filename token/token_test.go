@@ -0,0 +1,228 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+	"github.com/uright008/go-openbmclapi-reborn/mockcenter"
+	"github.com/uright008/go-openbmclapi-reborn/resilience"
+)
+
+const (
+	testClusterID     = "test-cluster"
+	testClusterSecret = "test-secret"
+)
+
+func TestMain(m *testing.M) {
+	// 缩短续期间隔的下限，使测试在秒级内完成
+	minRefreshDelay = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// newTestManager 创建连接到模拟中心服务器的令牌管理器
+func newTestManager(t *testing.T, secret string) (*TokenManager, *mockcenter.Server) {
+	t.Helper()
+
+	center := mockcenter.NewServer(testClusterID, testClusterSecret)
+	t.Cleanup(center.Close)
+
+	tm := NewTokenManager(testClusterID, secret, center.URL, logger.New(false))
+	tm.backoff = resilience.Backoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond, Factor: 2}
+	t.Cleanup(tm.Close)
+	return tm, center
+}
+
+// count 返回kind类型的令牌请求次数
+func count(requests []string, kind string) int {
+	n := 0
+	for _, request := range requests {
+		if request == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGetTokenSingleFlight(t *testing.T) {
+	tm, center := newTestManager(t, testClusterSecret)
+
+	tokens := make([]string, 16)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := tm.GetToken(context.Background())
+			if err != nil {
+				t.Errorf("GetToken: %v", err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	for _, token := range tokens {
+		if token == "" || token != tokens[0] {
+			t.Fatalf("并发获取的令牌不一致: %v", tokens)
+		}
+	}
+	if requests := center.TokenRequests(); len(requests) != 1 {
+		t.Errorf("令牌接口收到 %v, want 1次请求", requests)
+	}
+
+	// 令牌有效时直接返回，不再请求
+	if token, err := tm.GetToken(context.Background()); err != nil || token != tokens[0] {
+		t.Errorf("GetToken = %q, %v, want %q", token, err, tokens[0])
+	}
+	if requests := center.TokenRequests(); len(requests) != 1 {
+		t.Errorf("令牌接口收到 %v, want 1次请求", requests)
+	}
+	if state := tm.State(); !state.Valid || state.Failures != 0 {
+		t.Errorf("State = %+v", state)
+	}
+}
+
+func TestGetTokenWrongSecret(t *testing.T) {
+	tm, _ := newTestManager(t, "wrong-secret")
+
+	if _, err := tm.GetToken(context.Background()); err == nil {
+		t.Fatal("密钥错误时 GetToken 应当失败")
+	}
+	if state := tm.State(); state.Valid || state.Failures != 1 || state.LastError == "" {
+		t.Errorf("State = %+v", state)
+	}
+}
+
+func TestGetTokenRespectsContext(t *testing.T) {
+	tm, center := newTestManager(t, testClusterSecret)
+	center.SetTokenStatus(http.StatusServiceUnavailable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tm.GetToken(ctx); err == nil {
+		t.Fatal("ctx取消后 GetToken 应当返回错误")
+	}
+}
+
+func TestInvalidateRefetches(t *testing.T) {
+	tm, center := newTestManager(t, testClusterSecret)
+	ctx := context.Background()
+
+	first, err := tm.GetToken(ctx)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+
+	// 过时令牌的失效请求被忽略
+	tm.Invalidate("stale-token")
+	if token, _ := tm.GetToken(ctx); token != first {
+		t.Fatalf("过时令牌使当前令牌失效: %q -> %q", first, token)
+	}
+
+	tm.Invalidate(first)
+	second, err := tm.GetToken(ctx)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if second == "" || second == first {
+		t.Errorf("失效后 GetToken = %q, want 新令牌", second)
+	}
+	// 失效的令牌不能用于续期，需要重新进行挑战认证
+	if requests := center.TokenRequests(); count(requests, "fetch") != 2 || count(requests, "refresh") != 0 {
+		t.Errorf("令牌接口收到 %v, want 2次挑战认证", requests)
+	}
+}
+
+func TestRefreshAtHalfLife(t *testing.T) {
+	tm, center := newTestManager(t, testClusterSecret)
+	center.SetTokenTTL(400 * time.Millisecond)
+
+	start := time.Now()
+	first, err := tm.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+
+	waitFor(t, "续期", func() bool {
+		return count(center.TokenRequests(), "refresh") >= 1
+	})
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("续期发生在 %v 后, want 约在有效期过半时", elapsed)
+	}
+
+	waitFor(t, "新令牌", func() bool {
+		token, err := tm.GetToken(context.Background())
+		return err == nil && token != first
+	})
+	if requests := center.TokenRequests(); count(requests, "fetch") != 1 {
+		t.Errorf("令牌接口收到 %v, 续期不应重新进行挑战认证", requests)
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	tm, center := newTestManager(t, testClusterSecret)
+	center.SetTokenTTL(100 * time.Millisecond)
+
+	if _, err := tm.GetToken(context.Background()); err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+
+	// 中心服务器故障时续期按退避策略重试
+	center.SetTokenStatus(http.StatusServiceUnavailable)
+	waitFor(t, "续期失败", func() bool {
+		return tm.State().Failures >= 3
+	})
+	if state := tm.State(); state.LastError == "" {
+		t.Errorf("续期失败后 State = %+v", state)
+	}
+
+	// 恢复后重新获取令牌
+	center.SetTokenStatus(0)
+	waitFor(t, "恢复", func() bool {
+		state := tm.State()
+		return state.Valid && state.Failures == 0
+	})
+}
+
+func TestNextRefresh(t *testing.T) {
+	tm := NewTokenManager(testClusterID, testClusterSecret, "http://127.0.0.1:1", logger.New(false))
+	defer tm.Close()
+	tm.backoff = resilience.Backoff{Base: time.Second, Max: 4 * time.Second, Factor: 2}
+
+	now := time.Now()
+	tm.issuedAt = now
+	tm.expiresAt = now.Add(time.Hour)
+	if delay := tm.nextRefresh(0); delay < 29*time.Minute || delay > 30*time.Minute {
+		t.Errorf("nextRefresh(0) = %v, want 约30分钟", delay)
+	}
+
+	// 有效期很短时不低于最短间隔
+	tm.expiresAt = now
+	if delay := tm.nextRefresh(0); delay != minRefreshDelay {
+		t.Errorf("nextRefresh(0) = %v, want %v", delay, minRefreshDelay)
+	}
+
+	// 失败后按退避策略等待
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if delay := tm.nextRefresh(attempt + 1); delay != want {
+			t.Errorf("nextRefresh(%d) = %v, want %v", attempt+1, delay, want)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package upnp
 
 import (
 	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/huin/goupnp"
@@ -9,143 +11,197 @@ import (
 	"github.com/uright008/go-openbmclapi-reborn/logger"
 )
 
-// SetupUPnP 设置UPnP端口映射
-func SetupUPnP(port, publicPort int, logger *logger.Logger) (string, error) {
+const (
+	// leaseDuration 端口映射的租期(秒)
+	leaseDuration = 3600
+	// mappingDescription 端口映射的描述
+	mappingDescription = "openbmclapi"
+)
+
+// renewInterval 端口映射的续期间隔，需短于租期
+var renewInterval = 30 * time.Minute
+
+// mappingProtocols 需要映射的协议
+var mappingProtocols = []string{"TCP", "UDP"}
+
+// Client IGD设备上的WAN连接服务，WANIPConnection和WANPPPConnection均实现了该接口
+type Client interface {
+	GetExternalIPAddress() (string, error)
+	AddPortMapping(remoteHost string, externalPort uint16, protocol string, internalPort uint16,
+		internalClient string, enabled bool, description string, leaseDuration uint32) error
+	DeletePortMapping(remoteHost string, externalPort uint16, protocol string) error
+}
+
+// localAddresser 能够返回与IGD通信所用本机地址的客户端
+type localAddresser interface {
+	LocalAddr() net.IP
+}
+
+// Mapper 维护一组端口映射并定期续期
+type Mapper struct {
+	client     Client
+	port       int
+	publicPort int
+	logger     *logger.Logger
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMapper 使用指定的IGD客户端创建端口映射器，将公网端口publicPort映射到本机端口port
+func NewMapper(client Client, port, publicPort int, logger *logger.Logger) *Mapper {
+	return &Mapper{
+		client:     client,
+		port:       port,
+		publicPort: publicPort,
+		logger:     logger,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// SetupUPnP 发现IGD设备并设置UPnP端口映射，返回映射器和外部IP地址，
+// 程序退出时需调用Mapper.Close删除映射
+func SetupUPnP(port, publicPort int, logger *logger.Logger) (*Mapper, string, error) {
 	logger.Info("正在设置UPnP端口映射...")
 
 	// 发现IGD设备
-	clients, externalIP, err := discoverIGD()
+	client, err := Discover()
 	if err != nil {
-		return "", fmt.Errorf("无法发现IGD设备: %w", err)
+		return nil, "", err
 	}
 
-	if len(clients) == 0 {
-		return "", fmt.Errorf("未找到IGD设备")
+	mapper := NewMapper(client, port, publicPort, logger)
+	externalIP, err := mapper.Start()
+	if err != nil {
+		return nil, "", err
 	}
+	return mapper, externalIP, nil
+}
 
-	client := clients[0]
-
-	// 获取外部IP地址
-	if externalIP == "" {
-		externalIP, err = client.GetExternalIPAddress()
-		if err != nil {
-			return "", fmt.Errorf("无法获取外部IP地址: %w", err)
-		}
+// Start 获取外部IP地址、执行端口映射并启动定期续期
+func (m *Mapper) Start() (string, error) {
+	externalIP, err := m.client.GetExternalIPAddress()
+	if err != nil {
+		return "", fmt.Errorf("无法获取外部IP地址: %w", err)
 	}
-
-	logger.Info("外部IP地址: %s", externalIP)
+	m.logger.Info("外部IP地址: %s", externalIP)
 
 	// 执行端口映射
-	err = doPortMap(client, port, publicPort, logger)
-	if err != nil {
+	if err := m.mapPorts(); err != nil {
 		return "", fmt.Errorf("端口映射失败: %w", err)
 	}
 
 	// 设置定期续期
-	go func() {
-		ticker := time.NewTicker(30 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				err := doPortMap(client, port, publicPort, logger)
-				if err != nil {
-					logger.Error("UPnP端口映射续期失败: %v", err)
-				} else {
-					logger.Debug("UPnP端口映射续期成功")
-				}
-			}
-		}
-	}()
+	go m.renew()
 
-	logger.Info("UPnP端口映射设置成功")
+	m.logger.Info("UPnP端口映射设置成功")
 	return externalIP, nil
 }
 
-// discoverIGD 发现IGD设备
-func discoverIGD() ([]*internetgateway1.WANIPConnection1, string, error) {
-	var clients []*internetgateway1.WANIPConnection1
-	var externalIP string
-
-	// 尝试WANIPConnection
-	devs, err := goupnp.DiscoverDevices(internetgateway1.URN_WANIPConnection_1)
-	if err != nil {
-		return nil, "", err
-	}
+// Close 停止续期并删除端口映射
+func (m *Mapper) Close() error {
+	var err error
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		<-m.done
 
-	for _, dev := range devs {
-		if dev.Root == nil {
-			continue
+		for _, protocol := range mappingProtocols {
+			if deleteErr := m.client.DeletePortMapping("", uint16(m.publicPort), protocol); deleteErr != nil && err == nil {
+				err = fmt.Errorf("无法删除%s端口映射: %w", protocol, deleteErr)
+			}
 		}
-
-		client, err := internetgateway1.NewWANIPConnection1ClientsByURL(dev.Location)
-		if err != nil {
-			continue
+		if err == nil {
+			m.logger.Info("已删除UPnP端口映射")
 		}
+	})
+	return err
+}
+
+// renew 定期续期端口映射，直到Close被调用
+func (m *Mapper) renew() {
+	defer close(m.done)
 
-		for _, c := range client {
-			clients = append(clients, c)
-			if externalIP == "" {
-				ip, err := c.GetExternalIPAddress()
-				if err == nil {
-					externalIP = ip
-				}
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			err := m.mapPorts()
+			if err != nil {
+				m.logger.Error("UPnP端口映射续期失败: %v", err)
+			} else {
+				m.logger.Debug("UPnP端口映射续期成功")
 			}
 		}
 	}
+}
 
-	// 如果没有找到WANIPConnection，尝试WANPPPConnection
-	if len(clients) == 0 {
-		devs, err := goupnp.DiscoverDevices(internetgateway1.URN_WANPPPConnection_1)
-		if err != nil {
-			return nil, "", err
-		}
+// mapPorts 执行端口映射
+func (m *Mapper) mapPorts() error {
+	m.logger.Debug("映射端口 %d 到 %d", m.port, m.publicPort)
 
-		for _, dev := range devs {
-			if dev.Root == nil {
-				continue
-			}
+	internalClient := "0.0.0.0"
+	if addresser, ok := m.client.(localAddresser); ok {
+		if ip := addresser.LocalAddr(); ip != nil {
+			internalClient = ip.String()
+		}
+	}
 
-			client, err := internetgateway1.NewWANPPPConnection1ClientsByURL(dev.Location)
-			if err != nil {
-				continue
-			}
+	for _, protocol := range mappingProtocols {
+		// 删除已有的端口映射
+		_ = m.client.DeletePortMapping("", uint16(m.publicPort), protocol)
 
-			for _, c := range client {
-				clients = append(clients, &internetgateway1.WANIPConnection1{ServiceClient: c.ServiceClient})
-				if externalIP == "" {
-					ip, err := c.GetExternalIPAddress()
-					if err == nil {
-						externalIP = ip
-					}
-				}
-			}
+		err := m.client.AddPortMapping("", uint16(m.publicPort), protocol, uint16(m.port),
+			internalClient, true, mappingDescription, leaseDuration)
+		if err != nil {
+			return fmt.Errorf("%s端口映射失败: %w", protocol, err)
 		}
 	}
 
-	return clients, externalIP, nil
+	return nil
 }
 
-// doPortMap 执行端口映射
-func doPortMap(client *internetgateway1.WANIPConnection1, port, publicPort int, logger *logger.Logger) error {
-	logger.Debug("映射端口 %d 到 %d", port, publicPort)
-
-	// 删除已有的端口映射
-	_ = client.DeletePortMapping("", uint16(publicPort), "TCP")
-	_ = client.DeletePortMapping("", uint16(publicPort), "UDP")
-
-	// 创建新的TCP端口映射
-	err := client.AddPortMapping("", uint16(publicPort), "TCP", uint16(port), "0.0.0.0", true, "openbmclapi", 3600)
+// Discover 发现IGD设备，优先使用WANIPConnection，其次WANPPPConnection
+func Discover() (Client, error) {
+	// 尝试WANIPConnection
+	ipClients, _, err := internetgateway1.NewWANIPConnection1Clients()
 	if err != nil {
-		return fmt.Errorf("TCP端口映射失败: %w", err)
+		return nil, fmt.Errorf("无法发现IGD设备: %w", err)
+	}
+	for _, c := range ipClients {
+		if reachable(c) {
+			return c, nil
+		}
 	}
 
-	// 创建新的UDP端口映射
-	err = client.AddPortMapping("", uint16(publicPort), "UDP", uint16(port), "0.0.0.0", true, "openbmclapi", 3600)
+	// 如果没有找到WANIPConnection，尝试WANPPPConnection
+	pppClients, _, err := internetgateway1.NewWANPPPConnection1Clients()
 	if err != nil {
-		return fmt.Errorf("UDP端口映射失败: %w", err)
+		return nil, fmt.Errorf("无法发现IGD设备: %w", err)
+	}
+	for _, c := range pppClients {
+		if reachable(c) {
+			return c, nil
+		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("未找到IGD设备")
 }
+
+// reachable 判断客户端能否返回外部IP地址
+func reachable(c Client) bool {
+	_, err := c.GetExternalIPAddress()
+	return err == nil
+}
+
+// 确保goupnp的客户端实现了Client接口
+var (
+	_ Client         = (*internetgateway1.WANIPConnection1)(nil)
+	_ Client         = (*internetgateway1.WANPPPConnection1)(nil)
+	_ localAddresser = (*goupnp.ServiceClient)(nil)
+)
@@ -0,0 +1,212 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uright008/go-openbmclapi-reborn/logger"
+)
+
+func TestMain(m *testing.M) {
+	// 缩短续期间隔，使测试在秒级内完成
+	renewInterval = 20 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// portMapping fakeClient上的一条端口映射
+type portMapping struct {
+	internalPort   uint16
+	internalClient string
+	description    string
+	lease          uint32
+}
+
+// fakeClient 在内存中记录端口映射的IGD客户端
+type fakeClient struct {
+	mu       sync.Mutex
+	mappings map[string]portMapping
+	adds     int
+	addErr   error
+	// failDelete 删除该协议的映射时返回错误
+	failDelete string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{mappings: make(map[string]portMapping)}
+}
+
+func (c *fakeClient) GetExternalIPAddress() (string, error) {
+	return "203.0.113.1", nil
+}
+
+func (c *fakeClient) AddPortMapping(remoteHost string, externalPort uint16, protocol string, internalPort uint16,
+	internalClient string, enabled bool, description string, leaseDuration uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.addErr != nil {
+		return c.addErr
+	}
+	c.adds++
+	c.mappings[fmt.Sprintf("%s/%d", protocol, externalPort)] = portMapping{
+		internalPort:   internalPort,
+		internalClient: internalClient,
+		description:    description,
+		lease:          leaseDuration,
+	}
+	return nil
+}
+
+func (c *fakeClient) DeletePortMapping(remoteHost string, externalPort uint16, protocol string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if protocol == c.failDelete {
+		return errors.New("ActionNotAuthorized")
+	}
+	key := fmt.Sprintf("%s/%d", protocol, externalPort)
+	if _, ok := c.mappings[key]; !ok {
+		return errors.New("NoSuchEntryInArray")
+	}
+	delete(c.mappings, key)
+	return nil
+}
+
+func (c *fakeClient) LocalAddr() net.IP {
+	return net.ParseIP("192.168.1.10")
+}
+
+func (c *fakeClient) snapshot() (map[string]portMapping, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mappings := make(map[string]portMapping, len(c.mappings))
+	for key, mapping := range c.mappings {
+		mappings[key] = mapping
+	}
+	return mappings, c.adds
+}
+
+func (c *fakeClient) setAddErr(err error) {
+	c.mu.Lock()
+	c.addErr = err
+	c.mu.Unlock()
+}
+
+// waitFor 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStartMapsPorts(t *testing.T) {
+	client := newFakeClient()
+	mapper := NewMapper(client, 4000, 14000, logger.New(false))
+	defer mapper.Close()
+
+	externalIP, err := mapper.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if externalIP != "203.0.113.1" {
+		t.Errorf("外部地址 = %s, want 203.0.113.1", externalIP)
+	}
+
+	mappings, _ := client.snapshot()
+	want := portMapping{internalPort: 4000, internalClient: "192.168.1.10", description: mappingDescription, lease: leaseDuration}
+	for _, protocol := range []string{"TCP", "UDP"} {
+		if got := mappings[protocol+"/14000"]; got != want {
+			t.Errorf("%s映射 = %+v, want %+v", protocol, got, want)
+		}
+	}
+}
+
+func TestStartFailsOnMappingError(t *testing.T) {
+	client := newFakeClient()
+	client.setAddErr(errors.New("ConflictInMappingEntry"))
+
+	mapper := NewMapper(client, 4000, 14000, logger.New(false))
+	if _, err := mapper.Start(); err == nil {
+		t.Fatal("端口映射失败时 Start 应当返回错误")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	client := newFakeClient()
+	mapper := NewMapper(client, 4000, 14000, logger.New(false))
+	defer mapper.Close()
+
+	if _, err := mapper.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// 续期失败后继续尝试，恢复后重新建立映射
+	client.setAddErr(errors.New("路由器无响应"))
+	time.Sleep(3 * renewInterval)
+	_, before := client.snapshot()
+	client.setAddErr(nil)
+
+	waitFor(t, "续期", func() bool {
+		mappings, adds := client.snapshot()
+		return adds >= before+2 && len(mappings) == 2
+	})
+}
+
+func TestCloseRemovesMappings(t *testing.T) {
+	client := newFakeClient()
+	mapper := NewMapper(client, 4000, 14000, logger.New(false))
+
+	if _, err := mapper.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := mapper.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	mappings, adds := client.snapshot()
+	if len(mappings) != 0 {
+		t.Errorf("关闭后仍有映射: %v", mappings)
+	}
+
+	// 关闭后不再续期
+	time.Sleep(3 * renewInterval)
+	if _, after := client.snapshot(); after != adds {
+		t.Errorf("关闭后仍在续期: %d -> %d", adds, after)
+	}
+
+	// 重复关闭不会再次删除映射
+	if err := mapper.Close(); err != nil {
+		t.Errorf("重复 Close: %v", err)
+	}
+}
+
+func TestCloseReportsDeleteError(t *testing.T) {
+	client := newFakeClient()
+	mapper := NewMapper(client, 4000, 14000, logger.New(false))
+
+	if _, err := mapper.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	client.mu.Lock()
+	client.failDelete = "TCP"
+	client.mu.Unlock()
+
+	if err := mapper.Close(); err == nil {
+		t.Error("删除映射失败时 Close 应当返回错误")
+	}
+	// TCP映射删除失败时仍会删除UDP映射
+	if mappings, _ := client.snapshot(); len(mappings) != 1 {
+		t.Errorf("剩余映射 = %v, want 只剩TCP", mappings)
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultIPEchoURL 返回请求方公网IP的HTTP服务
+const DefaultIPEchoURL = "https://api.ipify.org"
+
+// privateNetworks 不能从公网直接访问的地址段
+var privateNetworks = mustParseCIDRs(
+	"10.0.0.0/8",     // RFC 1918
+	"172.16.0.0/12",  // RFC 1918
+	"192.168.0.0/16", // RFC 1918
+	"100.64.0.0/10",  // 运营商级NAT (RFC 6598)
+	"127.0.0.0/8",    // 回环地址
+	"169.254.0.0/16", // 链路本地地址
+	"::1/128",        // IPv6回环地址
+	"fc00::/7",       // IPv6唯一本地地址 (ULA)
+	"fe80::/10",      // IPv6链路本地地址
+)
+
+// mustParseCIDRs 解析地址段列表，仅用于包级常量
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPrivateIP 检查IP是否为私有IP，包括RFC 1918、运营商级NAT、回环、链路本地以及IPv6唯一本地地址
+func IsPrivateIP(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicIP 检查字符串是否为可从公网访问的单播地址
+func isPublicIP(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	return !IsPrivateIP(ipStr)
+}
+
+// IPDetector 公网IP的获取方式
+type IPDetector interface {
+	Detect() (string, error)
+}
+
+// IPDetectorFunc 将函数适配为IPDetector
+type IPDetectorFunc func() (string, error)
+
+// Detect 调用函数本身
+func (f IPDetectorFunc) Detect() (string, error) {
+	return f()
+}
+
+// StaticIP 使用配置中指定的地址，配置的地址可能是域名，因此不做校验
+func StaticIP(ip string) IPDetector {
+	return IPDetectorFunc(func() (string, error) {
+		if ip == "" {
+			return "", errors.New("未配置公网地址")
+		}
+		return ip, nil
+	})
+}
+
+// UPnPIP 使用路由器通过UPnP报告的外部地址，多层NAT时外部地址仍是私有地址，此时不采用
+func UPnPIP(externalIP func() (string, error)) IPDetector {
+	return IPDetectorFunc(func() (string, error) {
+		ip, err := externalIP()
+		if err != nil {
+			return "", fmt.Errorf("无法获取UPnP外部地址: %w", err)
+		}
+		if !isPublicIP(ip) {
+			return "", fmt.Errorf("UPnP外部地址 %s 不是公网地址", ip)
+		}
+		return ip, nil
+	})
+}
+
+// InterfaceIP 从本机网卡地址中查找公网地址，优先返回IPv4地址。
+// addrs为nil时使用net.InterfaceAddrs
+func InterfaceIP(addrs func() ([]net.Addr, error)) IPDetector {
+	if addrs == nil {
+		addrs = net.InterfaceAddrs
+	}
+
+	return IPDetectorFunc(func() (string, error) {
+		list, err := addrs()
+		if err != nil {
+			return "", fmt.Errorf("无法获取网卡地址: %w", err)
+		}
+
+		var ipv6 string
+		for _, addr := range list {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			default:
+				continue
+			}
+
+			if !isPublicIP(ip.String()) {
+				continue
+			}
+			if ip.To4() != nil {
+				return ip.String(), nil
+			}
+			if ipv6 == "" {
+				ipv6 = ip.String()
+			}
+		}
+
+		if ipv6 != "" {
+			return ipv6, nil
+		}
+		return "", errors.New("网卡上没有公网地址")
+	})
+}
+
+// HTTPEchoIP 请求返回请求方地址的HTTP服务，响应体为纯文本IP地址。
+// client为nil时使用10秒超时的默认客户端
+func HTTPEchoIP(client *http.Client, url string) IPDetector {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return IPDetectorFunc(func() (string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", fmt.Errorf("无法请求 %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("请求 %s 失败，状态码: %d", url, resp.StatusCode)
+		}
+
+		// IP地址不会超过64字节，限制读取量以防异常响应
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+		if err != nil {
+			return "", fmt.Errorf("无法读取 %s 的响应: %w", url, err)
+		}
+
+		ip := strings.TrimSpace(string(body))
+		if !isPublicIP(ip) {
+			return "", fmt.Errorf("%s 返回的不是公网地址: %q", url, ip)
+		}
+		return ip, nil
+	})
+}
+
+// DetectPublicIP 依次尝试各个获取方式，返回第一个成功的结果
+func DetectPublicIP(detectors ...IPDetector) (string, error) {
+	var errs []error
+	for _, detector := range detectors {
+		ip, err := detector.Detect()
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", fmt.Errorf("无法获取公网IP: %w", errors.Join(errs...))
+}
+
+// GetPublicIP 获取公网IP地址，依次尝试本机网卡地址和HTTP回显服务
+func GetPublicIP() (string, error) {
+	return DetectPublicIP(
+		InterfaceIP(nil),
+		HTTPEchoIP(nil, DefaultIPEchoURL),
+	)
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubDetector 返回固定结果并记录调用次数
+type stubDetector struct {
+	ip    string
+	err   error
+	calls int
+}
+
+func (d *stubDetector) Detect() (string, error) {
+	d.calls++
+	return d.ip, d.err
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"8.8.8.8", false},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fc00::1", true},
+		{"fe80::1", true},
+		{"2001:db8::1", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsPrivateIP(tt.ip); got != tt.want {
+			t.Errorf("IsPrivateIP(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestDetectPublicIPOrder(t *testing.T) {
+	failing := &stubDetector{err: errors.New("失败")}
+	first := &stubDetector{ip: "1.1.1.1"}
+	second := &stubDetector{ip: "2.2.2.2"}
+
+	ip, err := DetectPublicIP(failing, first, second)
+	if err != nil || ip != "1.1.1.1" {
+		t.Fatalf("DetectPublicIP = %q, %v, want 1.1.1.1", ip, err)
+	}
+	if failing.calls != 1 || first.calls != 1 || second.calls != 0 {
+		t.Errorf("调用次数 = %d, %d, %d, want 1, 1, 0", failing.calls, first.calls, second.calls)
+	}
+}
+
+func TestDetectPublicIPAllFail(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+
+	_, err := DetectPublicIP(&stubDetector{err: errA}, &stubDetector{err: errB})
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("DetectPublicIP error = %v, 应包含所有方式的错误", err)
+	}
+}
+
+func TestStaticIPFirst(t *testing.T) {
+	fallback := &stubDetector{ip: "2.2.2.2"}
+
+	ip, err := DetectPublicIP(StaticIP("1.1.1.1"), fallback)
+	if err != nil || ip != "1.1.1.1" || fallback.calls != 0 {
+		t.Errorf("配置了地址时 DetectPublicIP = %q, %v, 后续方式调用 %d 次", ip, err, fallback.calls)
+	}
+
+	// 未配置地址时继续尝试后续方式
+	ip, err = DetectPublicIP(StaticIP(""), fallback)
+	if err != nil || ip != "2.2.2.2" || fallback.calls != 1 {
+		t.Errorf("未配置地址时 DetectPublicIP = %q, %v, 后续方式调用 %d 次", ip, err, fallback.calls)
+	}
+}
+
+func TestUPnPIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		err     error
+		wantErr bool
+	}{
+		{"公网地址", "203.0.113.1", nil, false},
+		{"多层NAT", "192.168.1.1", nil, true},
+		{"运营商级NAT", "100.64.1.1", nil, true},
+		{"获取失败", "", errors.New("无响应"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := UPnPIP(func() (string, error) { return tt.ip, tt.err }).Detect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect = %q, %v", ip, err)
+			}
+			if !tt.wantErr && ip != tt.ip {
+				t.Errorf("Detect = %q, want %q", ip, tt.ip)
+			}
+		})
+	}
+}
+
+func TestInterfaceIP(t *testing.T) {
+	ipNet := func(s string) net.Addr {
+		return &net.IPNet{IP: net.ParseIP(s), Mask: net.CIDRMask(24, 32)}
+	}
+
+	tests := []struct {
+		name  string
+		addrs []net.Addr
+		want  string
+	}{
+		{"优先IPv4", []net.Addr{ipNet("127.0.0.1"), ipNet("2001:db8::1"), ipNet("10.0.0.1"), ipNet("203.0.113.1")}, "203.0.113.1"},
+		{"只有IPv6", []net.Addr{ipNet("fe80::1"), &net.IPAddr{IP: net.ParseIP("2001:db8::1")}}, "2001:db8::1"},
+		{"没有公网地址", []net.Addr{ipNet("127.0.0.1"), ipNet("192.168.1.1"), ipNet("0.0.0.0")}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := InterfaceIP(func() ([]net.Addr, error) { return tt.addrs, nil }).Detect()
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Detect = %q, want error", ip)
+				}
+				return
+			}
+			if err != nil || ip != tt.want {
+				t.Errorf("Detect = %q, %v, want %q", ip, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPEchoIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"公网地址", http.StatusOK, "203.0.113.1\n", "203.0.113.1", false},
+		{"私有地址", http.StatusOK, "10.0.0.1", "", true},
+		{"不是地址", http.StatusOK, "<html></html>", "", true},
+		{"IPv6", http.StatusOK, "2001:db8::1", "2001:db8::1", false},
+		{"错误状态码", http.StatusServiceUnavailable, "203.0.113.1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			ip, err := HTTPEchoIP(server.Client(), server.URL).Detect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect = %q, %v", ip, err)
+			}
+			if ip != tt.want {
+				t.Errorf("Detect = %q, want %q", ip, tt.want)
+			}
+		})
+	}
+}
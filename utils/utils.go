@@ -2,12 +2,16 @@ package utils
 
 import (
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // SignRequest 使用HMAC-SHA256签名请求
@@ -18,34 +22,48 @@ func SignRequest(secret, message string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// VerifySignature 验证HMAC-SHA256签名
-func VerifySignature(secret, message, signature string) bool {
-	expected := SignRequest(secret, message)
-	return hmac.Equal([]byte(expected), []byte(signature))
+// ComputeSign 按中心服务器的算法计算下载签名：
+// base64url(sha1(secret + hash + e))，其中e为36进制的毫秒级过期时间戳
+func ComputeSign(secret, hash, expire string) string {
+	h := sha1.New()
+	h.Write([]byte(secret))
+	h.Write([]byte(hash))
+	h.Write([]byte(expire))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 }
 
-// GetPublicIP 获取公网IP地址
-func GetPublicIP() (string, error) {
-	// 这里可以实现获取公网IP的逻辑
-	// 暂时返回一个默认值
-	return "", fmt.Errorf("未实现获取公网IP功能")
+// SignDownload 为hash生成在expireAt之前有效的签名，返回签名和过期参数e
+func SignDownload(secret, hash string, expireAt time.Time) (string, string) {
+	expire := strconv.FormatInt(expireAt.UnixMilli(), 36)
+	return ComputeSign(secret, hash, expire), expire
 }
 
-// IsPrivateIP 检查IP是否为私有IP
-func IsPrivateIP(ipStr string) bool {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
+// CheckSign 校验下载签名是否正确且尚未过期
+func CheckSign(secret, hash, sign, expire string) bool {
+	if sign == "" || expire == "" {
 		return false
 	}
 
-	// 私有IP地址范围
-	_, private24BitBlock, _ := net.ParseCIDR("10.0.0.0/8")
-	_, private20BitBlock, _ := net.ParseCIDR("172.16.0.0/12")
-	_, private16BitBlock, _ := net.ParseCIDR("192.168.0.0/16")
+	expected := ComputeSign(secret, hash, expire)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(sign)) != 1 {
+		return false
+	}
 
-	return private24BitBlock.Contains(ip) ||
-		private20BitBlock.Contains(ip) ||
-		private16BitBlock.Contains(ip)
+	expireAt, err := strconv.ParseInt(expire, 36, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Now().UnixMilli() < expireAt
+}
+
+// CheckSignQuery 从查询参数中读取签名(s或sign)和过期时间(e)并校验
+func CheckSignQuery(secret, hash string, query url.Values) bool {
+	sign := query.Get("s")
+	if sign == "" {
+		sign = query.Get("sign")
+	}
+	return CheckSign(secret, hash, sign, query.Get("e"))
 }
 
 // ExtractHashFromPath 从路径中提取哈希值
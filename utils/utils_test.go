@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret = "cluster_secret"
+	testHash   = "d41d8cd98f00b204e9800998ecf8427e00000000"
+	// testExpire 2100-01-01T00:00:00Z的毫秒时间戳的36进制表示
+	testExpire = "1gcmxpmo0"
+	testSign   = "dsIJw2kGfP-p2bIQ9Q1ncnXU_w0"
+	// expiredExpire 2000-01-01T00:00:00Z
+	expiredExpire = "c2wfoqo0"
+	expiredSign   = "JpKvAaM1PKDMsk8_bJxcQRc5RMo"
+)
+
+func TestComputeSign(t *testing.T) {
+	if got := ComputeSign(testSecret, testHash, testExpire); got != testSign {
+		t.Errorf("ComputeSign = %q, want %q", got, testSign)
+	}
+	if got := ComputeSign(testSecret, testHash, expiredExpire); got != expiredSign {
+		t.Errorf("ComputeSign = %q, want %q", got, expiredSign)
+	}
+}
+
+func TestSignDownload(t *testing.T) {
+	sign, expire := SignDownload(testSecret, testHash, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	if sign != testSign || expire != testExpire {
+		t.Errorf("SignDownload = %q, %q, want %q, %q", sign, expire, testSign, testExpire)
+	}
+}
+
+func TestCheckSign(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   string
+		sign   string
+		expire string
+		want   bool
+	}{
+		{"有效", testHash, testSign, testExpire, true},
+		{"已过期", testHash, expiredSign, expiredExpire, false},
+		{"哈希被篡改", "e41d8cd98f00b204e9800998ecf8427e00000000", testSign, testExpire, false},
+		{"签名被篡改", testHash, "esIJw2kGfP-p2bIQ9Q1ncnXU_w0", testExpire, false},
+		{"过期时间被篡改", testHash, testSign, "1gcmxpmo1", false},
+		{"缺少签名", testHash, "", testExpire, false},
+		{"缺少过期时间", testHash, testSign, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSign(testSecret, tt.hash, tt.sign, tt.expire); got != tt.want {
+				t.Errorf("CheckSign = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSignQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  bool
+	}{
+		{"s参数", url.Values{"s": {testSign}, "e": {testExpire}}, true},
+		{"旧版sign参数", url.Values{"sign": {testSign}, "e": {testExpire}}, true},
+		{"s参数优先", url.Values{"s": {testSign}, "sign": {"invalid"}, "e": {testExpire}}, true},
+		{"s参数错误", url.Values{"s": {"invalid"}, "sign": {testSign}, "e": {testExpire}}, false},
+		{"缺少e参数", url.Values{"s": {testSign}}, false},
+		{"已过期", url.Values{"s": {expiredSign}, "e": {expiredExpire}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSignQuery(testSecret, testHash, tt.query); got != tt.want {
+				t.Errorf("CheckSignQuery = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}